@@ -0,0 +1,106 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+
+	v1 "github.com/bhojpur/events/pkg/api/v1"
+	"github.com/bhojpur/events/pkg/engine"
+	"github.com/bhojpur/events/pkg/engine/bridge"
+)
+
+var serveOpts struct {
+	Addr        string
+	StopTimeout time.Duration
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serves the Bhojpur Events API until terminated",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var lc engine.Lifecycle
+		lc.StopTimeout = serveOpts.StopTimeout
+
+		srv := grpc.NewServer()
+		v1.RegisterEventsServiceServer(srv, bridge.NewServer(engine.DefaultBus()))
+
+		// a failure to serve shuts the process down like a signal
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		serveErr := make(chan error, 1)
+
+		lc.OnStart.AddContext(func(ctx context.Context) error {
+			lis, err := net.Listen("tcp", serveOpts.Addr)
+			if err != nil {
+				return err
+			}
+			log.WithField("addr", lis.Addr().String()).Info("serving Bhojpur Events API")
+			go func() {
+				if err := srv.Serve(lis); err != nil {
+					log.WithError(err).Error("cannot serve Bhojpur Events API")
+					serveErr <- err
+					cancel()
+				}
+			}()
+			return nil
+		}, engine.HookName("grpc"))
+
+		// let running streams finish, unless that takes too long
+		lc.OnStop.AddContext(func(ctx context.Context) error {
+			done := make(chan struct{})
+			go func() {
+				srv.GracefulStop()
+				close(done)
+			}()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				srv.Stop()
+				return ctx.Err()
+			}
+		}, engine.HookName("grpc"))
+
+		if err := lc.Run(ctx); err != nil {
+			return err
+		}
+		select {
+		case err := <-serveErr:
+			return fmt.Errorf("cannot serve Bhojpur Events API: %w", err)
+		default:
+			return nil
+		}
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveOpts.Addr, "addr", ":7777", "address to serve the API on")
+	serveCmd.Flags().DurationVar(&serveOpts.StopTimeout, "stop-timeout", engine.DefaultStopTimeout, "time to wait for running streams when terminated")
+	rootCmd.AddCommand(serveCmd)
+}
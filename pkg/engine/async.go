@@ -0,0 +1,222 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy decides what an asynchronous Bus does when a listener's
+// queue is full.
+type OverflowPolicy int
+
+const (
+	// Block makes Dispatch wait until the listener's queue has room.
+	Block OverflowPolicy = iota
+	// DropNewest discards the event being dispatched.
+	DropNewest
+	// DropOldest discards the oldest queued event to make room for the one
+	// being dispatched.
+	DropOldest
+)
+
+// Async makes a Bus deliver events asynchronously. Each listener gets its own
+// queue holding up to queueSize events and a goroutine that calls it with the
+// queued events in dispatch order, so a slow listener only delays itself.
+// When a queue is full, policy decides whether Dispatch blocks or an event
+// is dropped; dropped events are counted by Bus.Dropped and
+// Subscription.Dropped.
+//
+// Call Close to deliver the remaining queued events and stop the goroutines.
+func Async(queueSize int, policy OverflowPolicy) BusOption {
+	if queueSize < 1 {
+		panic("engine: Async queue size must be positive")
+	}
+	return func(b *Bus) {
+		b.queueSize = queueSize
+		b.overflow = policy
+	}
+}
+
+// delivery is an event queued together with the context it was dispatched
+// with.
+type delivery struct {
+	ctx context.Context
+	ev  interface{}
+}
+
+// queue buffers the events of a single listener on an asynchronous Bus.
+type queue struct {
+	events   chan delivery
+	stop     chan struct{}
+	stopOnce sync.Once
+	policy   OverflowPolicy
+	dropped  uint64 // accessed atomically
+}
+
+func newQueue(size int, policy OverflowPolicy) *queue {
+	return &queue{
+		events: make(chan delivery, size),
+		stop:   make(chan struct{}),
+		policy: policy,
+	}
+}
+
+// push queues d according to the overflow policy. It reports false if an
+// event was dropped, and returns the context error if the context of d was
+// done while push was blocked.
+func (q *queue) push(d delivery) (bool, error) {
+	switch q.policy {
+	case DropNewest:
+		select {
+		case q.events <- d:
+			return true, nil
+		default:
+			return false, nil
+		}
+	case DropOldest:
+		dropped := false
+		for {
+			select {
+			case q.events <- d:
+				return !dropped, nil
+			default:
+			}
+			select {
+			case old := <-q.events:
+				withdrawReply(old.ctx)
+				dropped = true
+			default:
+			}
+		}
+	default:
+		select {
+		case q.events <- d:
+			return true, nil
+		case <-q.stop:
+			return false, nil
+		case <-d.ctx.Done():
+			return true, d.ctx.Err()
+		}
+	}
+}
+
+// close stops the worker of the queue once it has drained the queued events.
+func (q *queue) close() {
+	q.stopOnce.Do(func() { close(q.stop) })
+}
+
+// run calls l with every queued event until the queue is stopped, then
+// delivers whatever is left in it.
+func (q *queue) run(b *Bus, l *listener) {
+	for {
+		select {
+		case d := <-q.events:
+			q.deliver(b, l, d)
+		case <-q.stop:
+			for {
+				select {
+				case d := <-q.events:
+					q.deliver(b, l, d)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver calls l with a queued event, unless its context is already done.
+func (q *queue) deliver(b *Bus, l *listener, d delivery) {
+	if d.ctx.Err() == nil {
+		b.call(d.ctx, l, d.ev)
+	}
+}
+
+// startQueue gives l its own queue and worker goroutine.
+// b.mu must be held.
+func (b *Bus) startQueue(l *listener) {
+	l.queue = newQueue(b.queueSize, b.overflow)
+	b.workers.Add(1)
+	go func() {
+		defer b.workers.Done()
+		l.queue.run(b, l)
+	}()
+}
+
+// enqueue hands an event to the queue of l, counting it if it was dropped.
+func (b *Bus) enqueue(ctx context.Context, l *listener, ev interface{}) error {
+	queued, err := l.queue.push(delivery{ctx: ctx, ev: ev})
+	if !queued {
+		atomic.AddUint64(&l.queue.dropped, 1)
+		atomic.AddUint64(&b.dropped, 1)
+		withdrawReply(ctx)
+	}
+	return err
+}
+
+// Dropped returns the number of events an asynchronous Bus has discarded
+// because a listener's queue was full, or the listener was cancelled while
+// Dispatch was blocked on it.
+func (b *Bus) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+// Dropped returns the number of events discarded for this listener. It is
+// always zero for listeners on a synchronous Bus.
+func (s *Subscription) Dropped() uint64 {
+	if s.l.queue == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&s.l.queue.dropped)
+}
+
+// Close removes all listeners from an asynchronous Bus and waits until the
+// events already queued for them have been delivered. The Bus must not be
+// used after Close. Close is a no-op on a synchronous Bus.
+func (b *Bus) Close() {
+	if b.queueSize == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	var closing []*listener
+	for _, ls := range b.listeners {
+		closing = append(closing, ls...)
+	}
+	closing = append(closing, b.wildcards...)
+	b.listeners = make(map[reflect.Type][]*listener)
+	b.interfaces = make([]reflect.Type, 0)
+	b.fallthroughs = nil
+	b.wildcards = nil
+	b.cache = new(sync.Map)
+	b.mu.Unlock()
+
+	for _, l := range closing {
+		if l.queue != nil {
+			l.queue.close()
+		}
+	}
+	b.workers.Wait()
+}
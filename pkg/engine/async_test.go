@@ -0,0 +1,275 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testAsyncEvent int
+
+// blockingListener records the events it receives. The first call blocks
+// until release is closed, so tests can fill up the queue behind it.
+type blockingListener struct {
+	mu       sync.Mutex
+	received []testAsyncEvent
+	started  chan struct{}
+	release  chan struct{}
+	once     sync.Once
+}
+
+func newBlockingListener() *blockingListener {
+	return &blockingListener{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+}
+
+func (bl *blockingListener) listen(ev testAsyncEvent) {
+	bl.once.Do(func() {
+		close(bl.started)
+		<-bl.release
+	})
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.received = append(bl.received, ev)
+}
+
+func (bl *blockingListener) events() []testAsyncEvent {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	return bl.received
+}
+
+func TestAsyncDoesNotBlockDispatch(t *testing.T) {
+	b := NewBus(Async(1, DropNewest))
+	bl := newBlockingListener()
+	sub := b.AddListener(bl.listen)
+
+	b.Dispatch(testAsyncEvent(1))
+	<-bl.started
+	b.Dispatch(testAsyncEvent(2)) // queued
+	b.Dispatch(testAsyncEvent(3)) // dropped
+	b.Dispatch(testAsyncEvent(4)) // dropped
+
+	close(bl.release)
+	b.Close()
+
+	if got, want := bl.events(), []testAsyncEvent{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if got := sub.Dropped(); got != 2 {
+		t.Errorf("sub.Dropped() = %d, want 2", got)
+	}
+	if got := b.Dropped(); got != 2 {
+		t.Errorf("b.Dropped() = %d, want 2", got)
+	}
+}
+
+func TestAsyncDropOldest(t *testing.T) {
+	b := NewBus(Async(2, DropOldest))
+	bl := newBlockingListener()
+	sub := b.AddListener(bl.listen)
+
+	b.Dispatch(testAsyncEvent(1))
+	<-bl.started
+	for i := 2; i <= 5; i++ {
+		b.Dispatch(testAsyncEvent(i))
+	}
+
+	close(bl.release)
+	b.Close()
+
+	if got, want := bl.events(), []testAsyncEvent{1, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if got := sub.Dropped(); got != 2 {
+		t.Errorf("sub.Dropped() = %d, want 2", got)
+	}
+}
+
+func TestAsyncBlock(t *testing.T) {
+	b := NewBus(Async(1, Block))
+	bl := newBlockingListener()
+	b.AddListener(bl.listen)
+
+	b.Dispatch(testAsyncEvent(1))
+	<-bl.started
+	b.Dispatch(testAsyncEvent(2)) // queued
+
+	done := make(chan struct{})
+	go func() {
+		b.Dispatch(testAsyncEvent(3))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("Dispatch returned while the listener's queue was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(bl.release)
+	<-done
+	b.Close()
+
+	if got, want := bl.events(), []testAsyncEvent{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if got := b.Dropped(); got != 0 {
+		t.Errorf("b.Dropped() = %d, want 0", got)
+	}
+}
+
+func TestAsyncCancelUnblocksDispatch(t *testing.T) {
+	b := NewBus(Async(1, Block))
+	bl := newBlockingListener()
+	sub := b.AddListener(bl.listen)
+
+	b.Dispatch(testAsyncEvent(1))
+	<-bl.started
+	b.Dispatch(testAsyncEvent(2)) // queued
+
+	done := make(chan struct{})
+	go func() {
+		b.Dispatch(testAsyncEvent(3))
+		close(done)
+	}()
+
+	sub.Cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Dispatch still blocked after the listener was cancelled")
+	}
+
+	close(bl.release)
+	b.Close()
+
+	if got, want := bl.events(), []testAsyncEvent{1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+}
+
+func TestAsyncSlowListenerDoesNotDelayOthers(t *testing.T) {
+	b := NewBus(Async(1, Block))
+	bl := newBlockingListener()
+	b.AddListener(bl.listen)
+
+	triggered := make(chan testAsyncEvent, 1)
+	b.AddListener(func(ev testAsyncEvent) { triggered <- ev })
+
+	b.Dispatch(testAsyncEvent(1))
+
+	select {
+	case <-triggered:
+	case <-time.After(time.Second):
+		t.Errorf("fast listener was not triggered while slow listener was blocked")
+	}
+
+	close(bl.release)
+	b.Close()
+}
+
+func TestAsyncCloseDeliversQueuedEvents(t *testing.T) {
+	b := NewBus(Async(10, Block))
+
+	var mu sync.Mutex
+	var received []testAsyncEvent
+	b.AddListener(func(ev testAsyncEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, ev)
+	})
+
+	for i := 1; i <= 5; i++ {
+		b.Dispatch(testAsyncEvent(i))
+	}
+	b.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got, want := received, []testAsyncEvent{1, 2, 3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+}
+
+func TestAsyncSkipsCancelledEvents(t *testing.T) {
+	b := NewBus(Async(2, Block))
+	bl := newBlockingListener()
+	b.AddListener(bl.listen)
+
+	b.Dispatch(testAsyncEvent(1))
+	<-bl.started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.DispatchContext(ctx, testAsyncEvent(2))
+	b.Dispatch(testAsyncEvent(3))
+	cancel()
+
+	close(bl.release)
+	b.Close()
+
+	if got, want := bl.events(), []testAsyncEvent{1, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+}
+
+func TestAsyncBlockedDispatchCancelled(t *testing.T) {
+	b := NewBus(Async(1, Block))
+	bl := newBlockingListener()
+	b.AddListener(bl.listen)
+
+	b.Dispatch(testAsyncEvent(1))
+	<-bl.started
+	b.Dispatch(testAsyncEvent(2)) // queued
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.DispatchContext(ctx, testAsyncEvent(3)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("DispatchContext() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(bl.release)
+	b.Close()
+
+	if got := b.Dropped(); got != 0 {
+		t.Errorf("b.Dropped() = %d for a cancelled dispatch, want 0", got)
+	}
+}
+
+func TestCloseSync(t *testing.T) {
+	b := NewBus()
+	triggered := 0
+	b.AddListener(func(testAsyncEvent) { triggered++ })
+
+	b.Close()
+	b.Dispatch(testAsyncEvent(1))
+
+	if triggered != 1 {
+		t.Errorf("listener triggered %d times after Close on a synchronous Bus, want 1", triggered)
+	}
+}
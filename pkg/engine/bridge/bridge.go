@@ -0,0 +1,73 @@
+package bridge
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+/*
+Package bridge connects the Events engine package to the EventsService gRPC
+API, so that in-process listeners and remote clients see the same events.
+
+Code running engines reports their lifecycle by dispatching StatusChanged
+and LogSlice events, most easily with PublishStatus and PublishLogSlice.
+Listeners on the same Bus receive them like any other event, and Server
+streams them to the clients of the Subscribe and Listen calls:
+
+	srv := grpc.NewServer()
+	v1.RegisterEventsServiceServer(srv, bridge.NewServer(bus))
+
+The remaining calls of the service are not implemented by Server; embed it in
+a type that provides them.
+*/
+
+import (
+	"context"
+
+	v1 "github.com/bhojpur/events/pkg/api/v1"
+	"github.com/bhojpur/events/pkg/engine"
+)
+
+// StatusChanged is dispatched when the status of an engine changes, for
+// example when it enters a new phase.
+type StatusChanged struct {
+	Status *v1.EngineStatus
+}
+
+// LogSlice is dispatched for each slice of the log output of an engine.
+type LogSlice struct {
+	Slice *v1.LogSliceEvent
+}
+
+// PublishStatus dispatches a StatusChanged event for status on bus, or on the
+// default Bus if bus is nil.
+func PublishStatus(ctx context.Context, bus *engine.Bus, status *v1.EngineStatus) error {
+	if bus == nil {
+		bus = engine.DefaultBus()
+	}
+	return engine.PublishContext(ctx, bus, StatusChanged{Status: status})
+}
+
+// PublishLogSlice dispatches a LogSlice event for slice on bus, or on the
+// default Bus if bus is nil.
+func PublishLogSlice(ctx context.Context, bus *engine.Bus, slice *v1.LogSliceEvent) error {
+	if bus == nil {
+		bus = engine.DefaultBus()
+	}
+	return engine.PublishContext(ctx, bus, LogSlice{Slice: slice})
+}
@@ -0,0 +1,118 @@
+package bridge
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"strconv"
+	"strings"
+
+	v1 "github.com/bhojpur/events/pkg/api/v1"
+)
+
+// MatchFilter reports whether status matches filter. The status matches if
+// filter is empty, or if all terms of any of its expressions match.
+//
+// Terms can refer to the following fields:
+//
+//	name, phase, details, owner, trigger, success,
+//	repository.host, repository.owner, repository.repo, repository.ref,
+//	repository.revision, annotation.<key>
+//
+// Phases and triggers are compared by their lowercase names without prefix,
+// such as "running" and "push"; success is "true" or "false". A term with an
+// unknown field never matches, unless negated.
+func MatchFilter(status *v1.EngineStatus, filter []*v1.FilterExpression) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, expr := range filter {
+		if matchTerms(status, expr.Terms) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchTerms(status *v1.EngineStatus, terms []*v1.FilterTerm) bool {
+	for _, term := range terms {
+		if matchTerm(status, term) == term.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+func matchTerm(status *v1.EngineStatus, term *v1.FilterTerm) bool {
+	value, ok := field(status, term.Field)
+	switch term.Operation {
+	case v1.FilterOp_OP_EXISTS:
+		return ok && value != ""
+	case v1.FilterOp_OP_EQUALS:
+		return ok && value == term.Value
+	case v1.FilterOp_OP_STARTS_WITH:
+		return ok && strings.HasPrefix(value, term.Value)
+	case v1.FilterOp_OP_ENDS_WITH:
+		return ok && strings.HasSuffix(value, term.Value)
+	case v1.FilterOp_OP_CONTAINS:
+		return ok && strings.Contains(value, term.Value)
+	}
+	return false
+}
+
+// field returns the value of the named field of status, and whether the field
+// is known.
+func field(status *v1.EngineStatus, name string) (string, bool) {
+	md := status.GetMetadata()
+	repo := md.GetRepository()
+	switch name {
+	case "name":
+		return status.Name, true
+	case "phase":
+		return strings.ToLower(strings.TrimPrefix(status.Phase.String(), "PHASE_")), true
+	case "details":
+		return status.Details, true
+	case "owner":
+		return md.GetOwner(), true
+	case "trigger":
+		return strings.ToLower(strings.TrimPrefix(md.GetTrigger().String(), "TRIGGER_")), true
+	case "success":
+		return strconv.FormatBool(status.GetConditions().GetSuccess()), true
+	case "repository.host":
+		return repo.GetHost(), true
+	case "repository.owner":
+		return repo.GetOwner(), true
+	case "repository.repo":
+		return repo.GetRepo(), true
+	case "repository.ref":
+		return repo.GetRef(), true
+	case "repository.revision":
+		return repo.GetRevision(), true
+	}
+	if key := strings.TrimPrefix(name, "annotation."); key != name {
+		for _, a := range md.GetAnnotations() {
+			if a.Key == key {
+				return a.Value, true
+			}
+		}
+		return "", false
+	}
+	return "", false
+}
@@ -0,0 +1,78 @@
+package bridge
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"testing"
+
+	v1 "github.com/bhojpur/events/pkg/api/v1"
+)
+
+func TestMatchFilter(t *testing.T) {
+	status := &v1.EngineStatus{
+		Name:  "build-42",
+		Phase: v1.EnginePhase_PHASE_RUNNING,
+		Metadata: &v1.EngineMetadata{
+			Owner:       "alice",
+			Trigger:     v1.EngineTrigger_TRIGGER_PUSH,
+			Repository:  &v1.Repository{Host: "github.com", Repo: "events", Ref: "refs/heads/main"},
+			Annotations: []*v1.Annotation{{Key: "team", Value: "core"}},
+		},
+	}
+	term := func(field, value string, op v1.FilterOp, negate bool) *v1.FilterTerm {
+		return &v1.FilterTerm{Field: field, Value: value, Operation: op, Negate: negate}
+	}
+	expr := func(terms ...*v1.FilterTerm) *v1.FilterExpression {
+		return &v1.FilterExpression{Terms: terms}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		filter []*v1.FilterExpression
+		want   bool
+	}{
+		{"empty", nil, true},
+		{"equals", []*v1.FilterExpression{expr(term("name", "build-42", v1.FilterOp_OP_EQUALS, false))}, true},
+		{"not equals", []*v1.FilterExpression{expr(term("name", "build-1", v1.FilterOp_OP_EQUALS, false))}, false},
+		{"negated", []*v1.FilterExpression{expr(term("name", "build-1", v1.FilterOp_OP_EQUALS, true))}, true},
+		{"phase", []*v1.FilterExpression{expr(term("phase", "running", v1.FilterOp_OP_EQUALS, false))}, true},
+		{"trigger", []*v1.FilterExpression{expr(term("trigger", "push", v1.FilterOp_OP_EQUALS, false))}, true},
+		{"success", []*v1.FilterExpression{expr(term("success", "false", v1.FilterOp_OP_EQUALS, false))}, true},
+		{"suffix", []*v1.FilterExpression{expr(term("repository.ref", "/main", v1.FilterOp_OP_ENDS_WITH, false))}, true},
+		{"contains", []*v1.FilterExpression{expr(term("owner", "lic", v1.FilterOp_OP_CONTAINS, false))}, true},
+		{"annotation", []*v1.FilterExpression{expr(term("annotation.team", "core", v1.FilterOp_OP_EQUALS, false))}, true},
+		{"exists", []*v1.FilterExpression{expr(term("annotation.team", "", v1.FilterOp_OP_EXISTS, false))}, true},
+		{"missing", []*v1.FilterExpression{expr(term("annotation.owner", "", v1.FilterOp_OP_EXISTS, false))}, false},
+		{"unknown field", []*v1.FilterExpression{expr(term("color", "", v1.FilterOp_OP_EXISTS, false))}, false},
+		{"all terms", []*v1.FilterExpression{expr(
+			term("name", "build-", v1.FilterOp_OP_STARTS_WITH, false),
+			term("repository.host", "gitlab.com", v1.FilterOp_OP_EQUALS, false),
+		)}, false},
+		{"any expression", []*v1.FilterExpression{
+			expr(term("repository.host", "gitlab.com", v1.FilterOp_OP_EQUALS, false)),
+			expr(term("repository.repo", "events", v1.FilterOp_OP_EQUALS, false)),
+		}, true},
+	} {
+		if got := MatchFilter(status, tc.filter); got != tc.want {
+			t.Errorf("%s: MatchFilter() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package bridge
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	v1 "github.com/bhojpur/events/pkg/api/v1"
+	"github.com/bhojpur/events/pkg/engine"
+)
+
+// DefaultBufferSize is the number of events buffered for each stream if
+// Server.BufferSize is not set.
+const DefaultBufferSize = 256
+
+// Server implements the Subscribe and Listen calls of the EventsService by
+// streaming the StatusChanged and LogSlice events dispatched on a Bus.
+type Server struct {
+	v1.UnimplementedEventsServiceServer
+
+	// BufferSize is the number of events buffered for each stream. A client
+	// that falls further behind has its stream ended with
+	// codes.ResourceExhausted, rather than slowing down dispatching.
+	BufferSize int
+
+	bus *engine.Bus
+}
+
+var _ v1.EventsServiceServer = (*Server)(nil) // compile-time interface check
+
+// NewServer returns a Server streaming the events dispatched on bus, or on
+// the default Bus if bus is nil.
+func NewServer(bus *engine.Bus) *Server {
+	if bus == nil {
+		bus = engine.DefaultBus()
+	}
+	return &Server{bus: bus}
+}
+
+// stream buffers events of type T from the Bus until they can be sent.
+type stream[T any] struct {
+	events   chan T
+	overflow chan struct{} // closed when events is full
+	once     sync.Once     // closes overflow
+}
+
+// newStream returns a stream with the buffer size of s.
+func newStream[T any](s *Server) *stream[T] {
+	size := s.BufferSize
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	return &stream[T]{
+		events:   make(chan T, size),
+		overflow: make(chan struct{}),
+	}
+}
+
+// offer buffers ev, or closes overflow if the buffer is full.
+func (st *stream[T]) offer(ev T) {
+	select {
+	case st.events <- ev:
+	case <-st.overflow:
+	default:
+		st.once.Do(func() { close(st.overflow) })
+	}
+}
+
+// errTooSlow ends streams whose client can't keep up.
+var errTooSlow = status.Error(codes.ResourceExhausted, "client can't keep up with events")
+
+// Subscribe streams the status of every engine whose status changes and
+// matches the request filter.
+func (s *Server) Subscribe(req *v1.SubscribeRequest, srv v1.EventsService_SubscribeServer) error {
+	st := newStream[StatusChanged](s)
+	sub := engine.Subscribe(s.bus, func(ev StatusChanged) {
+		if ev.Status != nil && MatchFilter(ev.Status, req.Filter) {
+			st.offer(ev)
+		}
+	})
+	defer sub.Cancel()
+
+	ctx := srv.Context()
+	for {
+		select {
+		case ev := <-st.events:
+			if err := srv.Send(&v1.SubscribeResponse{Result: ev.Status}); err != nil {
+				return err
+			}
+		case <-st.overflow:
+			return errTooSlow
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Listen streams the status updates and log slices of the engine named in
+// the request, as requested. The stream ends once the engine is done.
+//
+// Listen only relays events dispatched while the stream is open: the Server
+// keeps no record of past events, so listening to an engine that is already
+// done lasts until the client cancels. Log slices are sent as they were
+// published for every value of req.Logs other than LOGS_DISABLED; rendering
+// them as unsliced, raw or HTML output is left to the client.
+func (s *Server) Listen(req *v1.ListenRequest, srv v1.EventsService_ListenServer) error {
+	if req.Name == "" {
+		return status.Error(codes.InvalidArgument, "name is required")
+	}
+
+	// Status updates are always observed to end the stream when the engine
+	// is done, but only sent if requested. Both kinds of events go through
+	// one wildcard listener to keep their order, also on an asynchronous
+	// Bus.
+	st := newStream[interface{}](s)
+	sub := engine.SubscribeAll(s.bus, func(_ context.Context, ev interface{}, _ engine.EventInfo) error {
+		switch ev := ev.(type) {
+		case StatusChanged:
+			if ev.Status != nil && ev.Status.Name == req.Name {
+				st.offer(ev)
+			}
+		case LogSlice:
+			if req.Logs != v1.ListenRequestLogs_LOGS_DISABLED && ev.Slice != nil && ev.Slice.Name == req.Name {
+				st.offer(ev)
+			}
+		}
+		return nil
+	})
+	defer sub.Cancel()
+
+	ctx := srv.Context()
+	for {
+		var ev interface{}
+		select {
+		case ev = <-st.events:
+		case <-st.overflow:
+			return errTooSlow
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		switch ev := ev.(type) {
+		case StatusChanged:
+			if req.Updates {
+				resp := &v1.ListenResponse{Content: &v1.ListenResponse_Update{Update: ev.Status}}
+				if err := srv.Send(resp); err != nil {
+					return err
+				}
+			}
+			if ev.Status.Phase == v1.EnginePhase_PHASE_DONE {
+				return nil
+			}
+		case LogSlice:
+			resp := &v1.ListenResponse{Content: &v1.ListenResponse_Slice{Slice: ev.Slice}}
+			if err := srv.Send(resp); err != nil {
+				return err
+			}
+		}
+	}
+}
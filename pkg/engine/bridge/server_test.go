@@ -0,0 +1,235 @@
+package bridge
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	v1 "github.com/bhojpur/events/pkg/api/v1"
+	"github.com/bhojpur/events/pkg/engine"
+)
+
+// startServer serves a Server for bus over an in-memory connection, and
+// returns a client for it.
+func startServer(t *testing.T, s *Server) v1.EventsServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	v1.RegisterEventsServiceServer(srv, s)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return v1.NewEventsServiceClient(conn)
+}
+
+// untilReceived calls publish repeatedly until a message arrives on recv, to
+// make sure the server side of a stream has subscribed to the Bus.
+func untilReceived(t *testing.T, publish func(), recv <-chan interface{}) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		publish()
+		select {
+		case <-recv:
+			return
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatalf("stream didn't start")
+		}
+	}
+}
+
+// receive forwards the messages of a stream to a channel, until it ends with
+// an error.
+func receive(recv func() (interface{}, error)) (<-chan interface{}, <-chan error) {
+	msgs, errc := make(chan interface{}, 100), make(chan error, 1)
+	go func() {
+		for {
+			msg, err := recv()
+			if err != nil {
+				errc <- err
+				return
+			}
+			msgs <- msg
+		}
+	}()
+	return msgs, errc
+}
+
+func TestSubscribe(t *testing.T) {
+	bus := engine.NewBus()
+	client := startServer(t, NewServer(bus))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.Subscribe(ctx, &v1.SubscribeRequest{Filter: []*v1.FilterExpression{
+		{Terms: []*v1.FilterTerm{{Field: "name", Value: "build-", Operation: v1.FilterOp_OP_STARTS_WITH}}},
+	}})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	msgs, _ := receive(func() (interface{}, error) { return stream.Recv() })
+
+	ctx0 := context.Background()
+	untilReceived(t, func() { PublishStatus(ctx0, bus, &v1.EngineStatus{Name: "build-ping"}) }, msgs)
+
+	PublishStatus(ctx0, bus, &v1.EngineStatus{Name: "deploy-1", Phase: v1.EnginePhase_PHASE_RUNNING})
+	PublishStatus(ctx0, bus, &v1.EngineStatus{Name: "build-1", Phase: v1.EnginePhase_PHASE_RUNNING})
+	PublishLogSlice(ctx0, bus, &v1.LogSliceEvent{Name: "build-1"})
+	PublishStatus(ctx0, bus, &v1.EngineStatus{Name: "build-1", Phase: v1.EnginePhase_PHASE_DONE})
+
+	for _, want := range []v1.EnginePhase{v1.EnginePhase_PHASE_RUNNING, v1.EnginePhase_PHASE_DONE} {
+		select {
+		case msg := <-msgs:
+			got := msg.(*v1.SubscribeResponse).Result
+			if got.Name != "build-1" || got.Phase != want {
+				t.Errorf("received %s in phase %v, want build-1 in phase %v", got.Name, got.Phase, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no status received")
+		}
+	}
+	select {
+	case msg := <-msgs:
+		t.Errorf("received unexpected %v", msg)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestListen(t *testing.T) {
+	bus := engine.NewBus()
+	client := startServer(t, NewServer(bus))
+
+	stream, err := client.Listen(context.Background(), &v1.ListenRequest{
+		Name:    "build-1",
+		Updates: true,
+		Logs:    v1.ListenRequestLogs_LOGS_RAW,
+	})
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	msgs, errc := receive(func() (interface{}, error) { return stream.Recv() })
+
+	ctx := context.Background()
+	untilReceived(t, func() {
+		PublishLogSlice(ctx, bus, &v1.LogSliceEvent{Name: "build-1", Type: v1.LogSliceType_SLICE_ABANDONED})
+	}, msgs)
+
+	// the stream observes the bus without listening for interface{}
+	for _, l := range bus.Listeners() {
+		if l.Interface {
+			t.Errorf("Listen registered a listener for %s", l.Type)
+		}
+	}
+
+	PublishStatus(ctx, bus, &v1.EngineStatus{Name: "build-1", Phase: v1.EnginePhase_PHASE_RUNNING})
+	PublishLogSlice(ctx, bus, &v1.LogSliceEvent{Name: "build-2", Payload: "other"})
+	PublishLogSlice(ctx, bus, &v1.LogSliceEvent{Name: "build-1", Type: v1.LogSliceType_SLICE_CONTENT, Payload: "hello"})
+	PublishStatus(ctx, bus, &v1.EngineStatus{Name: "build-1", Phase: v1.EnginePhase_PHASE_DONE})
+
+	select {
+	case err := <-errc:
+		if err != io.EOF {
+			t.Errorf("stream ended with %v, want EOF", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("stream didn't end")
+	}
+
+	// all messages were received before the end of the stream
+	var got []string
+	for len(msgs) > 0 {
+		switch c := (<-msgs).(*v1.ListenResponse).Content.(type) {
+		case *v1.ListenResponse_Update:
+			got = append(got, c.Update.Phase.String())
+		case *v1.ListenResponse_Slice:
+			if c.Slice.Type != v1.LogSliceType_SLICE_ABANDONED { // not a ping
+				got = append(got, c.Slice.Payload)
+			}
+		}
+	}
+
+	want := []string{"PHASE_RUNNING", "hello", "PHASE_DONE"}
+	if len(got) != len(want) {
+		t.Fatalf("received %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("received %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestListenTooSlow(t *testing.T) {
+	bus := engine.NewBus()
+	s := NewServer(bus)
+	s.BufferSize = 1
+	client := startServer(t, s)
+
+	stream, err := client.Listen(context.Background(), &v1.ListenRequest{Name: "build-1", Updates: true})
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	msgs, errc := receive(func() (interface{}, error) { return stream.Recv() })
+
+	ctx := context.Background()
+	untilReceived(t, func() { PublishStatus(ctx, bus, &v1.EngineStatus{Name: "build-1"}) }, msgs)
+	for i := 0; i < 10000; i++ {
+		PublishStatus(ctx, bus, &v1.EngineStatus{Name: "build-1"})
+	}
+
+	select {
+	case err := <-errc:
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("stream ended with %v, want ResourceExhausted", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("stream didn't end")
+	}
+}
+
+func TestListenWithoutName(t *testing.T) {
+	client := startServer(t, NewServer(engine.NewBus()))
+	stream, err := client.Listen(context.Background(), &v1.ListenRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Listen() error = %v, want InvalidArgument", err)
+	}
+}
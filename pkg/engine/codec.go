@@ -0,0 +1,40 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// EncodedEvent is an event serialized by an EventCodec.
+type EncodedEvent struct {
+	// Type is the name identifying the type of the event.
+	Type string
+	// Version is the schema version of Data, see Registry.Upcast.
+	Version int
+	// Data is the serialized event.
+	Data []byte
+}
+
+// EventCodec converts events into bytes and back, so they can leave the
+// process, e.g. to be stored in a Journal.
+type EventCodec interface {
+	// Encode serializes ev.
+	Encode(ev interface{}) (EncodedEvent, error)
+	// Decode restores an event serialized by Encode, with its original type.
+	Decode(enc EncodedEvent) (interface{}, error)
+}
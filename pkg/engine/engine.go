@@ -21,17 +21,154 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"sort"
 	"sync"
-)
+	"sync/atomic"
+	"time"
 
-var (
-	listenersMutex sync.RWMutex // protects listeners and interfaces
-	listeners      = make(map[reflect.Type][]interface{})
-	interfaces     = make([]reflect.Type, 0)
+	"github.com/bhojpur/events/pkg/temporal"
 )
 
+// Bus dispatches events to the listeners registered on it. Each Bus holds its
+// own registry, so independent components (or parallel tests) can each own an
+// isolated dispatcher. The zero value is an empty Bus ready to use.
+//
+// The package-level functions AddListener, Dispatch and DispatchUpdate operate
+// on a default Bus shared by the whole program.
+type Bus struct {
+	// mu protects listeners, interfaces and cache. The slices are
+	// copy-on-write: they are never modified in place, so Dispatch can keep
+	// using a snapshot after releasing the lock.
+	mu           sync.RWMutex
+	listeners    map[reflect.Type][]*listener
+	interfaces   []reflect.Type
+	fallthroughs []*listener // listeners with a MatchMode, see Match
+	wildcards    []*listener // see SubscribeAll
+	seq          uint64      // registration counter, protected by mu
+
+	// cache maps concrete event types to the result of match. It is
+	// replaced whenever listeners change, while holding mu for writing;
+	// entries are added while holding mu for reading.
+	cache *sync.Map
+
+	// asynchronous delivery, see Async
+	queueSize int
+	overflow  OverflowPolicy
+	workers   sync.WaitGroup
+	dropped   uint64 // accessed atomically
+
+	errorHandler ErrorHandler // protected by mu
+
+	// interceptors and handler (their chain around deliver) are protected
+	// by mu, see Use
+	interceptors []Interceptor
+	handler      Handler
+
+	// envelope settings, protected by mu, see EnableEnvelopes
+	envelopes bool
+	clock     temporal.Clock
+
+	// metrics is set atomically by EnableMetrics; eventStats maps event
+	// types to their *eventStats
+	metrics    int32
+	eventStats sync.Map
+}
+
+// BusOption configures a Bus created by NewBus.
+type BusOption func(*Bus)
+
+// NewBus returns a new Bus with no listeners. Without options, the Bus calls
+// listeners synchronously from Dispatch.
+func NewBus(opts ...BusOption) *Bus {
+	b := &Bus{
+		listeners:  make(map[reflect.Type][]*listener),
+		interfaces: make([]reflect.Type, 0),
+		cache:      new(sync.Map),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// defaultBus is the Bus used by the package-level functions.
+var defaultBus = NewBus()
+
+// DefaultBus returns the Bus used by the package-level functions.
+func DefaultBus() *Bus {
+	return defaultBus
+}
+
+// listener is a single registration of a listener function.
+type listener struct {
+	handle  func(ctx context.Context, ev interface{}) error // calls the listener function
+	name    string                                          // name of the listener function
+	evType  reflect.Type                                    // nil for wildcard listeners
+	queue   *queue                                          // non-nil on an asynchronous Bus
+	removed int32                                           // set atomically once the listener has been cancelled
+
+	priority int                      // see Priority
+	match    MatchMode                // see Match
+	filters  []func(interface{}) bool // see Filter
+	seq      uint64                   // registration order on the Bus
+
+	responder bool // registered with Respond
+
+	stats listenerStats // see EnableMetrics
+}
+
+// ListenerOption configures a listener when it is registered.
+type ListenerOption func(*listener)
+
+// Priority sets the priority of a listener. For every event, the matching
+// listeners are called in order of decreasing priority; listeners with the
+// same priority are called in the order they were registered, regardless of
+// whether they listen for the event's concrete type or an interface it
+// implements. The default priority is 0.
+func Priority(p int) ListenerOption {
+	return func(l *listener) {
+		l.priority = p
+	}
+}
+
+// call invokes l unless it has been cancelled, and reports its failure if it
+// returns an error or panics.
+func (b *Bus) call(ctx context.Context, l *listener, ev interface{}) (lerr *ListenerError) {
+	if atomic.LoadInt32(&l.removed) != 0 {
+		withdrawReply(ctx)
+		return nil
+	}
+	if b.metricsEnabled() {
+		start := time.Now()
+		defer func() { l.observeCall(start, lerr != nil) }()
+	}
+	lerr = l.invoke(ctx, ev)
+	if lerr != nil {
+		b.reportError(ctx, lerr)
+	}
+	return lerr
+}
+
+// Subscription is a handle to a registered listener, returned by AddListener.
+type Subscription struct {
+	bus  *Bus
+	l    *listener
+	once sync.Once
+}
+
+// Cancel removes the listener from the Bus it was registered on. Once Cancel
+// returns, the listener will not be called again, except for calls that were
+// already in progress. It is safe to call Cancel concurrently with Dispatch,
+// from within the listener itself, and more than once.
+func (s *Subscription) Cancel() {
+	s.once.Do(func() {
+		s.bus.removeListener(s.l)
+	})
+}
+
 // BadListenerError is raised via panic() when AddListener is called with an
 // invalid listener function.
 type BadListenerError string
@@ -40,13 +177,49 @@ func (why BadListenerError) Error() string {
 	return fmt.Sprintf("bad listener func: %s", string(why))
 }
 
-// AddListener registers a listener function that will be called when a matching
-// event is dispatched. The type of the function's first (and only) argument
-// declares the event type (or interface) to listen for.
-func AddListener(fn interface{}) {
-	listenersMutex.Lock()
-	defer listenersMutex.Unlock()
+// AddListener registers a listener function on the default Bus.
+// See Bus.AddListener for details.
+func AddListener(fn interface{}, opts ...ListenerOption) *Subscription {
+	return defaultBus.AddListener(fn, opts...)
+}
 
+// Dispatch sends an event to the listeners of the default Bus.
+// See Bus.Dispatch for details.
+func Dispatch(ev interface{}) {
+	defaultBus.Dispatch(ev)
+}
+
+// DispatchContext sends an event to the listeners of the default Bus,
+// passing ctx to them. See Bus.DispatchContext for details.
+func DispatchContext(ctx context.Context, ev interface{}) error {
+	return defaultBus.DispatchContext(ctx, ev)
+}
+
+// DispatchUpdate calls Update() on the event and then dispatches it on the
+// default Bus. See Bus.DispatchUpdate for details.
+func DispatchUpdate(ev Updater, update interface{}) {
+	defaultBus.DispatchUpdate(ev, update)
+}
+
+// DispatchUpdateContext calls Update() on the event and then dispatches it on
+// the default Bus, passing ctx to the listeners.
+// See Bus.DispatchUpdateContext for details.
+func DispatchUpdateContext(ctx context.Context, ev Updater, update interface{}) error {
+	return defaultBus.DispatchUpdateContext(ctx, ev, update)
+}
+
+// AddListener registers a listener function that will be called when a matching
+// event is dispatched. The type of the function's last argument declares the
+// event type (or interface) to listen for. It may be preceded by a
+// context.Context argument, which receives the context the event was
+// dispatched with (see DispatchContext). If the function's last result is an
+// error, it is passed to the error handler of the Bus along with any panic
+// raised by the listener (see SetErrorHandler); other results are ignored.
+// Options such as Priority control the order in which listeners are called.
+// To observe every event dispatched on the Bus, use SubscribeAll rather than
+// a listener for interface{}. The returned Subscription can be used to remove
+// the listener again.
+func (b *Bus) AddListener(fn interface{}, opts ...ListenerOption) *Subscription {
 	fnType := reflect.TypeOf(fn)
 
 	// check that the function type is what we think: # of inputs/outputs, etc.
@@ -54,47 +227,296 @@ func AddListener(fn interface{}) {
 	switch {
 	case fnType.Kind() != reflect.Func:
 		panic(BadListenerError("listener must be a function"))
-	case fnType.NumIn() != 1:
-		panic(BadListenerError("listener must take exactly one input argument"))
+	case fnType.NumIn() == 2 && fnType.In(0) != contextType:
+		panic(BadListenerError("listener with two input arguments must take a context.Context first"))
+	case fnType.NumIn() != 1 && fnType.NumIn() != 2:
+		panic(BadListenerError("listener must take exactly one input argument, optionally preceded by a context.Context"))
+	}
+
+	fnVal := reflect.ValueOf(fn)
+	takesContext := fnType.NumIn() == 2
+	// index of the error result, if any; other results are ignored
+	errIndex := fnType.NumOut() - 1
+	if errIndex >= 0 && fnType.Out(errIndex) != errorType {
+		errIndex = -1
+	}
+
+	// the last input parameter is the event
+	return b.register(&listener{
+		handle: func(ctx context.Context, ev interface{}) error {
+			var out []reflect.Value
+			if takesContext {
+				out = fnVal.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(ev)})
+			} else {
+				out = fnVal.Call([]reflect.Value{reflect.ValueOf(ev)})
+			}
+			if errIndex >= 0 && !out[errIndex].IsNil() {
+				return out[errIndex].Interface().(error)
+			}
+			return nil
+		},
+		name:   funcName(fnVal),
+		evType: fnType.In(fnType.NumIn() - 1),
+	}, opts)
+}
+
+// register applies opts to l, adds it to the registry and starts its queue on
+// an asynchronous Bus.
+func (b *Bus) register(l *listener, opts []ListenerOption) *Subscription {
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.stats.latency = newHistogram()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	l.seq = b.seq
+	b.cache = new(sync.Map)
+
+	if b.listeners == nil {
+		b.listeners = make(map[reflect.Type][]*listener)
+	}
+	if b.queueSize > 0 {
+		b.startQueue(l)
 	}
 
-	// the first input parameter is the event
-	evType := fnType.In(0)
+	if l.evType == nil {
+		b.wildcards = append(b.wildcards[:len(b.wildcards):len(b.wildcards)], l)
+		return &Subscription{bus: b, l: l}
+	}
 
 	// keep a list of listeners for each event type
-	listeners[evType] = append(listeners[evType], fn)
+	old := b.listeners[l.evType]
+	b.listeners[l.evType] = append(old[:len(old):len(old)], l)
 
 	// if eventType is an interface, store it in a separate list
 	// so we can check non-interface objects against all interfaces
-	if evType.Kind() == reflect.Interface {
-		interfaces = append(interfaces, evType)
+	if l.evType.Kind() == reflect.Interface && len(old) == 0 {
+		b.interfaces = append(b.interfaces[:len(b.interfaces):len(b.interfaces)], l.evType)
+	}
+
+	// listeners with a match mode are also checked against every other type
+	if l.match != 0 && l.evType.Kind() != reflect.Interface {
+		b.fallthroughs = append(b.fallthroughs[:len(b.fallthroughs):len(b.fallthroughs)], l)
 	}
+
+	return &Subscription{bus: b, l: l}
+}
+
+// removeListener unregisters l, dropping its event type from the registry
+// once no listeners are left for it.
+func (b *Bus) removeListener(l *listener) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	atomic.StoreInt32(&l.removed, 1)
+	if l.queue != nil {
+		l.queue.close()
+	}
+	b.cache = new(sync.Map)
+
+	if l.evType == nil {
+		b.wildcards = without(b.wildcards, l)
+		return
+	}
+	if l.match != 0 {
+		b.fallthroughs = without(b.fallthroughs, l)
+	}
+
+	remaining := without(b.listeners[l.evType], l)
+	if len(remaining) > 0 {
+		b.listeners[l.evType] = remaining
+		return
+	}
+
+	delete(b.listeners, l.evType)
+	if l.evType.Kind() == reflect.Interface {
+		interfaces := make([]reflect.Type, 0, len(b.interfaces))
+		for _, in := range b.interfaces {
+			if in != l.evType {
+				interfaces = append(interfaces, in)
+			}
+		}
+		b.interfaces = interfaces
+	}
+}
+
+// without returns a copy of ls without l.
+func without(ls []*listener, l *listener) []*listener {
+	remaining := make([]*listener, 0, len(ls))
+	for _, other := range ls {
+		if other != l {
+			remaining = append(remaining, other)
+		}
+	}
+	return remaining
 }
 
 // Dispatch sends an event to all registered listeners that were declared
 // to accept values of the event's type, or interfaces that the value implements.
-func Dispatch(ev interface{}) {
-	listenersMutex.RLock()
-	defer listenersMutex.RUnlock()
+// Listeners are called in order of decreasing priority, then in the order
+// they were registered (see Priority). On an asynchronous Bus, this is the
+// order in which the event is queued for them. Listeners are called without
+// holding the registry lock, so they may add or cancel listeners and dispatch
+// further events. On an asynchronous Bus (see Async), Dispatch queues the
+// event for each listener instead of calling it.
+func (b *Bus) Dispatch(ev interface{}) {
+	b.DispatchContext(context.Background(), ev)
+}
 
-	evType := reflect.TypeOf(ev)
-	vals := []reflect.Value{reflect.ValueOf(ev)}
+// DispatchContext is like Dispatch, but passes ctx to listeners that accept a
+// context.Context. Once ctx is done, the remaining listeners are skipped and
+// DispatchContext returns ctx.Err(). On an asynchronous Bus, events whose
+// context is done by the time they reach the front of a listener's queue are
+// skipped as well.
+//
+// The event passes through the interceptors of the Bus (see Use) before it
+// is delivered; if one of them vetoes it, DispatchContext returns that
+// interceptor's error. Otherwise, if synchronous listeners failed,
+// DispatchContext returns their failures as ListenerErrors.
+//
+// If envelopes are enabled (see EnableEnvelopes), the Envelope of the event is
+// attached to the context before the interceptors are called.
+func (b *Bus) DispatchContext(ctx context.Context, ev interface{}) error {
+	b.mu.RLock()
+	h := b.handler
+	envelopes, clock := b.envelopes, b.clock
+	b.mu.RUnlock()
 
-	// call listeners for the actual static type
-	callListeners(evType, vals)
+	if envelopes {
+		ctx = seal(ctx, clock)
+	}
+	if b.metricsEnabled() {
+		defer b.observeDispatch(reflect.TypeOf(ev), time.Now())
+	}
 
-	// also check if the type implements any of the registered interfaces
-	for _, in := range interfaces {
-		if evType.Implements(in) {
-			callListeners(in, vals)
+	if h == nil {
+		return b.deliver(ctx, ev)
+	}
+	return h(ctx, ev)
+}
+
+// deliver sends ev to the matching listeners. It is the innermost Handler of
+// the interceptor chain.
+func (b *Bus) deliver(ctx context.Context, ev interface{}) error {
+	var failed ListenerErrors
+	for _, bd := range b.match(reflect.TypeOf(ev)) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		arg := ev
+		if bd.adapt != nil {
+			var ok bool
+			if arg, ok = bd.adapt(ev); !ok {
+				continue
+			}
+		}
+		if bd.filters != nil && !b.accepts(ctx, bd.listener, arg) {
+			continue
+		}
+		lctx := ctx
+		if bd.info != nil {
+			lctx = context.WithValue(ctx, eventInfoKey{}, bd.info)
+		}
+		if bd.responder {
+			lctx = expectReply(lctx, ev)
+		}
+		if bd.queue != nil {
+			if err := b.enqueue(lctx, bd.listener, arg); err != nil {
+				return err
+			}
+		} else if lerr := b.call(lctx, bd.listener, arg); lerr != nil {
+			failed = append(failed, lerr)
 		}
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// binding is a listener matched to an event type. If adapt is not nil, it
+// converts events of that type into the argument the listener expects; it
+// reports false if the event can't be passed to the listener. Bindings of
+// wildcard listeners carry the EventInfo for the event type.
+type binding struct {
+	*listener
+	adapt func(ev interface{}) (interface{}, bool)
+	info  *EventInfo
 }
 
-func callListeners(t reflect.Type, vals []reflect.Value) {
-	for _, fn := range listeners[t] {
-		reflect.ValueOf(fn).Call(vals)
+// match returns the listeners for the actual static type, those of every
+// registered interface the type implements and those matching it through
+// their MatchMode, in the order they must be called. The result is cached
+// until the listeners of the Bus change, so that the cost of matching does
+// not grow with the number of interface listeners.
+func (b *Bus) match(evType reflect.Type) []binding {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.cache == nil {
+		// zero Bus without listeners
+		return nil
+	}
+	if matched, ok := b.cache.Load(evType); ok {
+		return matched.([]binding)
 	}
+
+	matched := b.resolve(evType)
+	b.cache.Store(evType, matched)
+	return matched
+}
+
+// resolve computes the result of match for evType. b.mu must be held.
+func (b *Bus) resolve(evType reflect.Type) []binding {
+	var matched []binding
+	for _, l := range b.listeners[evType] {
+		matched = append(matched, binding{listener: l})
+	}
+
+	// a nil event has no type to implement interfaces or to be matched
+	if evType != nil {
+		// also check if the type implements any of the registered interfaces
+		for _, in := range b.interfaces {
+			if evType.Implements(in) {
+				for _, l := range b.listeners[in] {
+					matched = append(matched, binding{listener: l})
+				}
+			}
+		}
+
+		// and whether listeners of other types accept it through their match
+		// mode
+		for _, l := range b.fallthroughs {
+			if adapt := l.adapter(evType); adapt != nil {
+				matched = append(matched, binding{listener: l, adapt: adapt})
+			}
+		}
+	}
+
+	// wildcard listeners receive everything
+	if len(b.wildcards) > 0 {
+		info := &EventInfo{
+			Type:      TypeName(evType),
+			Listeners: len(matched),
+		}
+		for _, l := range b.wildcards {
+			matched = append(matched, binding{listener: l, info: info})
+		}
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].priority != matched[j].priority {
+			return matched[i].priority > matched[j].priority
+		}
+		return matched[i].seq < matched[j].seq
+	})
+	return matched
 }
 
 // Updater is an interface that events can implement to combine updating and
@@ -106,7 +528,14 @@ type Updater interface {
 
 // DispatchUpdate calls Update() on the event and then dispatches it. This is a
 // shortcut for combining updates and dispatches into a single call.
-func DispatchUpdate(ev Updater, update interface{}) {
+func (b *Bus) DispatchUpdate(ev Updater, update interface{}) {
+	ev.Update(update)
+	b.Dispatch(ev)
+}
+
+// DispatchUpdateContext calls Update() on the event and then dispatches it
+// with DispatchContext.
+func (b *Bus) DispatchUpdateContext(ctx context.Context, ev Updater, update interface{}) error {
 	ev.Update(update)
-	Dispatch(ev)
+	return b.DispatchContext(ctx, ev)
 }
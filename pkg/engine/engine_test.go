@@ -21,7 +21,11 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -46,11 +50,16 @@ func (testEvent1) TestFunc1() {}
 func (*testEvent2) TestFunc2() {}
 
 func clearListeners() {
-	listenersMutex.Lock()
-	defer listenersMutex.Unlock()
-
-	listeners = make(map[reflect.Type][]interface{})
-	interfaces = make([]reflect.Type, 0)
+	defaultBus.mu.Lock()
+	defer defaultBus.mu.Unlock()
+
+	defaultBus.listeners = make(map[reflect.Type][]*listener)
+	defaultBus.interfaces = make([]reflect.Type, 0)
+	defaultBus.fallthroughs = nil
+	defaultBus.wildcards = nil
+	defaultBus.cache = new(sync.Map)
+	defaultBus.interceptors = nil
+	defaultBus.handler = nil
 }
 
 func TestStaticListener(t *testing.T) {
@@ -133,7 +142,7 @@ func TestBadListenerWrongInputs(t *testing.T) {
 			panic(err) // this is not the error we were looking for; re-panic
 		}
 
-		want := "bad listener func: listener must take exactly one input argument"
+		want := "bad listener func: listener must take exactly one input argument, optionally preceded by a context.Context"
 		if got := blErr.Error(); got != want {
 			t.Errorf(`BadListenerError.Error() = "%s", want "%s"`, got, want)
 		}
@@ -258,3 +267,398 @@ func TestDispatchUpdate(t *testing.T) {
 		t.Errorf("ev.update = %#v, want %#v", got, want)
 	}
 }
+
+func TestBusIsolation(t *testing.T) {
+	clearListeners()
+
+	b1, b2 := NewBus(), NewBus()
+	triggered1, triggered2 := false, false
+	b1.AddListener(func(testEvent1) { triggered1 = true })
+	b2.AddListener(func(testEvent1) { triggered2 = true })
+	AddListener(func(testEvent1) { t.Errorf("default bus listener triggered by another bus") })
+
+	b1.Dispatch(testEvent1{})
+
+	if !triggered1 {
+		t.Errorf("listener on dispatching bus failed to trigger")
+	}
+	if triggered2 {
+		t.Errorf("listener on another bus triggered")
+	}
+}
+
+func TestZeroBus(t *testing.T) {
+	var b Bus
+
+	triggered := false
+	b.AddListener(func(testInterface1) { triggered = true })
+	b.Dispatch(testEvent1{})
+
+	if !triggered {
+		t.Errorf("listener on zero Bus failed to trigger")
+	}
+}
+
+func TestBusDispatchUpdate(t *testing.T) {
+	t.Parallel()
+
+	b := NewBus()
+	triggered := false
+	b.AddListener(func(*testUpdateEvent) { triggered = true })
+
+	ev := &testUpdateEvent{}
+	b.DispatchUpdate(ev, "hello")
+
+	if !triggered {
+		t.Errorf("listener failed to trigger on Bus.DispatchUpdate()")
+	}
+	if got, want := ev.update, "hello"; got != want {
+		t.Errorf("ev.update = %#v, want %#v", got, want)
+	}
+}
+
+func TestDefaultBus(t *testing.T) {
+	clearListeners()
+
+	triggered := false
+	DefaultBus().AddListener(func(testEvent1) { triggered = true })
+	Dispatch(testEvent1{})
+
+	if !triggered {
+		t.Errorf("listener added to DefaultBus() failed to trigger on Dispatch()")
+	}
+}
+
+func TestCancelListener(t *testing.T) {
+	clearListeners()
+
+	count1, count2 := 0, 0
+	sub := AddListener(func(testEvent1) { count1++ })
+	AddListener(func(testEvent1) { count2++ })
+
+	Dispatch(testEvent1{})
+	sub.Cancel()
+	sub.Cancel() // cancelling twice is a no-op
+	Dispatch(testEvent1{})
+
+	if count1 != 1 {
+		t.Errorf("cancelled listener triggered %d times, want 1", count1)
+	}
+	if count2 != 2 {
+		t.Errorf("remaining listener triggered %d times, want 2", count2)
+	}
+}
+
+func TestCancelInterfaceListener(t *testing.T) {
+	b := NewBus()
+
+	sub1 := b.AddListener(func(testInterface1) {})
+	sub2 := b.AddListener(func(testInterface1) {})
+	if got := len(b.interfaces); got != 1 {
+		t.Fatalf("len(interfaces) = %d after adding two listeners of one interface, want 1", got)
+	}
+
+	sub1.Cancel()
+	if got := len(b.interfaces); got != 1 {
+		t.Errorf("len(interfaces) = %d with one listener left, want 1", got)
+	}
+
+	sub2.Cancel()
+	if got := len(b.interfaces); got != 0 {
+		t.Errorf("len(interfaces) = %d after removing last listener, want 0", got)
+	}
+	if _, ok := b.listeners[reflect.TypeOf((*testInterface1)(nil)).Elem()]; ok {
+		t.Errorf("listeners entry left behind after removing last listener")
+	}
+}
+
+func TestMultipleInterfaceListenersTriggerOnce(t *testing.T) {
+	b := NewBus()
+
+	count1, count2 := 0, 0
+	b.AddListener(func(testInterface1) { count1++ })
+	b.AddListener(func(testInterface1) { count2++ })
+	b.Dispatch(testEvent1{})
+
+	if count1 != 1 || count2 != 1 {
+		t.Errorf("interface listeners triggered (%d, %d) times, want (1, 1)", count1, count2)
+	}
+}
+
+func TestCancelFromListener(t *testing.T) {
+	b := NewBus()
+
+	count := 0
+	var sub *Subscription
+	sub = b.AddListener(func(testEvent1) {
+		count++
+		sub.Cancel()
+	})
+	b.Dispatch(testEvent1{})
+	b.Dispatch(testEvent1{})
+
+	if count != 1 {
+		t.Errorf("self-cancelling listener triggered %d times, want 1", count)
+	}
+}
+
+func TestCancelSkipsPendingCall(t *testing.T) {
+	b := NewBus()
+
+	var sub2 *Subscription
+	b.AddListener(func(testEvent1) { sub2.Cancel() })
+	sub2 = b.AddListener(func(testEvent1) { t.Errorf("listener cancelled during dispatch triggered") })
+	b.Dispatch(testEvent1{})
+}
+
+func TestCancelConcurrentWithDispatch(t *testing.T) {
+	b := NewBus()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			sub := b.AddListener(func(testInterface1) {})
+			sub.Cancel()
+		}()
+		go func() {
+			defer wg.Done()
+			b.Dispatch(testEvent1{})
+		}()
+	}
+	wg.Wait()
+
+	if got := len(b.interfaces); got != 0 {
+		t.Errorf("len(interfaces) = %d after all listeners cancelled, want 0", got)
+	}
+}
+
+type testContextKey struct{}
+
+func TestContextListener(t *testing.T) {
+	b := NewBus()
+
+	var got interface{}
+	b.AddListener(func(ctx context.Context, ev testEvent1) { got = ctx.Value(testContextKey{}) })
+	SubscribeContext(b, func(ctx context.Context, ev testInterface1) error {
+		if v := ctx.Value(testContextKey{}); v != "value" {
+			t.Errorf("SubscribeContext listener got context value %#v, want %#v", v, "value")
+		}
+		return nil
+	})
+
+	ctx := context.WithValue(context.Background(), testContextKey{}, "value")
+	if err := b.DispatchContext(ctx, testEvent1{}); err != nil {
+		t.Errorf("DispatchContext() = %v, want nil", err)
+	}
+
+	if got != "value" {
+		t.Errorf("listener got context value %#v, want %#v", got, "value")
+	}
+}
+
+func TestContextListenerWithoutContext(t *testing.T) {
+	b := NewBus()
+
+	var got context.Context
+	b.AddListener(func(ctx context.Context, ev testEvent1) { got = ctx })
+	b.Dispatch(testEvent1{})
+
+	if got == nil {
+		t.Errorf("listener got nil context from Dispatch()")
+	}
+}
+
+func TestDispatchContextCancelled(t *testing.T) {
+	b := NewBus()
+	b.AddListener(func(testEvent1) { t.Errorf("listener triggered with cancelled context") })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := b.DispatchContext(ctx, testEvent1{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("DispatchContext() = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestDispatchContextCancelledByListener(t *testing.T) {
+	b := NewBus()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.AddListener(func(testEvent1) { cancel() })
+	b.AddListener(func(testEvent1) { t.Errorf("listener triggered after context was cancelled") })
+
+	if err := b.DispatchContext(ctx, testEvent1{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("DispatchContext() = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestDispatchUpdateContext(t *testing.T) {
+	b := NewBus()
+
+	var got interface{}
+	b.AddListener(func(ctx context.Context, ev *testUpdateEvent) { got = ctx.Value(testContextKey{}) })
+
+	ctx := context.WithValue(context.Background(), testContextKey{}, "value")
+	ev := &testUpdateEvent{}
+	if err := b.DispatchUpdateContext(ctx, ev, "hello"); err != nil {
+		t.Errorf("DispatchUpdateContext() = %v, want nil", err)
+	}
+
+	if got != "value" {
+		t.Errorf("listener got context value %#v, want %#v", got, "value")
+	}
+	if ev.update != "hello" {
+		t.Errorf("ev.update = %#v, want %#v", ev.update, "hello")
+	}
+}
+
+func TestBadListenerContextNotFirst(t *testing.T) {
+	defer func() {
+		err := recover()
+
+		if err == nil {
+			t.Errorf("bad listener func (context not first) failed to trigger panic")
+			return
+		}
+
+		blErr, ok := err.(BadListenerError)
+		if !ok {
+			panic(err) // this is not the error we were looking for; re-panic
+		}
+
+		want := "bad listener func: listener with two input arguments must take a context.Context first"
+		if got := blErr.Error(); got != want {
+			t.Errorf(`BadListenerError.Error() = "%s", want "%s"`, got, want)
+		}
+	}()
+
+	NewBus().AddListener(func(testEvent1, context.Context) {})
+}
+
+func TestListenerOrder(t *testing.T) {
+	b := NewBus()
+
+	var order []string
+	b.AddListener(func(testInterface1) { order = append(order, "interface") })
+	b.AddListener(func(testEvent1) { order = append(order, "concrete") })
+	b.AddListener(func(interface{}) { order = append(order, "empty interface") })
+	Subscribe(b, func(testEvent1) { order = append(order, "typed") })
+	b.Dispatch(testEvent1{})
+
+	want := []string{"interface", "concrete", "empty interface", "typed"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("listeners called in order %v, want registration order %v", order, want)
+	}
+}
+
+func TestListenerPriority(t *testing.T) {
+	b := NewBus()
+
+	var order []string
+	b.AddListener(func(testEvent1) { order = append(order, "concrete 0") })
+	b.AddListener(func(testInterface1) { order = append(order, "interface -1") }, Priority(-1))
+	b.AddListener(func(testEvent1) { order = append(order, "concrete 10") }, Priority(10))
+	Subscribe(b, func(testInterface1) { order = append(order, "interface 10") }, Priority(10))
+	b.AddListener(func(interface{}) { order = append(order, "empty interface 5") }, Priority(5))
+	b.Dispatch(testEvent1{})
+
+	want := []string{"concrete 10", "interface 10", "empty interface 5", "concrete 0", "interface -1"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("listeners called in order %v, want %v", order, want)
+	}
+}
+
+func TestListenerPrioritySingleType(t *testing.T) {
+	b := NewBus()
+
+	var order []int
+	b.AddListener(func(testEvent1) { order = append(order, 1) }, Priority(1))
+	b.AddListener(func(testEvent1) { order = append(order, 2) }, Priority(2))
+	b.AddListener(func(testEvent1) { order = append(order, 3) }, Priority(3))
+	b.Dispatch(testEvent1{})
+	b.Dispatch(testEvent1{})
+
+	if want := []int{3, 2, 1, 3, 2, 1}; !reflect.DeepEqual(order, want) {
+		t.Errorf("listeners called in order %v, want %v", order, want)
+	}
+}
+
+func TestMatchCacheInvalidation(t *testing.T) {
+	b := NewBus()
+
+	count1, count2 := 0, 0
+	b.AddListener(func(testEvent1) { count1++ })
+	b.Dispatch(testEvent1{}) // populates the cache
+
+	sub := b.AddListener(func(testInterface1) { count2++ })
+	b.Dispatch(testEvent1{})
+	sub.Cancel()
+	b.Dispatch(testEvent1{})
+
+	if count1 != 3 {
+		t.Errorf("concrete listener triggered %d times, want 3", count1)
+	}
+	if count2 != 1 {
+		t.Errorf("interface listener triggered %d times, want 1", count2)
+	}
+}
+
+// benchInterface is instantiated with different type arguments to get many
+// distinct interface types that testEvent1 does not implement.
+type benchInterface[T any] interface {
+	benchMethod(T)
+}
+
+func addBenchListener[T any](b *Bus) {
+	Subscribe(b, func(benchInterface[T]) {})
+}
+
+var benchListeners = []func(*Bus){
+	addBenchListener[[0]int], addBenchListener[[1]int], addBenchListener[[2]int], addBenchListener[[3]int],
+	addBenchListener[[4]int], addBenchListener[[5]int], addBenchListener[[6]int], addBenchListener[[7]int],
+	addBenchListener[[8]int], addBenchListener[[9]int], addBenchListener[[10]int], addBenchListener[[11]int],
+	addBenchListener[[12]int], addBenchListener[[13]int], addBenchListener[[14]int], addBenchListener[[15]int],
+	addBenchListener[[16]int], addBenchListener[[17]int], addBenchListener[[18]int], addBenchListener[[19]int],
+	addBenchListener[[20]int], addBenchListener[[21]int], addBenchListener[[22]int], addBenchListener[[23]int],
+	addBenchListener[[24]int], addBenchListener[[25]int], addBenchListener[[26]int], addBenchListener[[27]int],
+	addBenchListener[[28]int], addBenchListener[[29]int], addBenchListener[[30]int], addBenchListener[[31]int],
+}
+
+// BenchmarkDispatchInterfaceListeners shows that the cost of Dispatch does
+// not depend on the number of registered interfaces the event does not
+// implement.
+func BenchmarkDispatchInterfaceListeners(b *testing.B) {
+	for _, n := range []int{0, 1, 8, 32} {
+		b.Run(fmt.Sprintf("interfaces=%d", n), func(b *testing.B) {
+			bus := NewBus()
+			Subscribe(bus, func(testInterface1) {})
+			for _, add := range benchListeners[:n] {
+				add(bus)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				bus.Dispatch(testEvent1{})
+			}
+		})
+	}
+}
+
+// BenchmarkDispatchAfterChange measures Dispatch when every event follows a
+// change to the listeners, so the cache never hits.
+func BenchmarkDispatchAfterChange(b *testing.B) {
+	bus := NewBus()
+	Subscribe(bus, func(testInterface1) {})
+	for _, add := range benchListeners {
+		add(bus)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Subscribe(bus, func(testEvent1) {}).Cancel()
+		bus.Dispatch(testEvent1{})
+	}
+}
@@ -0,0 +1,130 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/bhojpur/events/pkg/temporal"
+)
+
+// Envelope carries metadata about a dispatched event. Once enabled with
+// EnableEnvelopes, the Bus attaches a new Envelope to the context of every
+// event it dispatches, where interceptors and listeners accepting a
+// context.Context can read it with EnvelopeFromContext.
+type Envelope struct {
+	// ID uniquely identifies the dispatch.
+	ID string
+	// Time is when the event was dispatched, according to the Bus clock.
+	Time temporal.Interval
+	// Source names the component that dispatched the event, see WithSource.
+	Source string
+	// CorrelationID groups the events that belong to one logical operation,
+	// see WithCorrelationID. It is inherited from the causing event, and
+	// defaults to the ID of the event otherwise.
+	CorrelationID string
+	// CausationID is the ID of the event whose listener dispatched this one,
+	// if any.
+	CausationID string
+}
+
+type (
+	envelopeKey    struct{}
+	sourceKey      struct{}
+	correlationKey struct{}
+)
+
+// EnvelopeFromContext returns the Envelope of the event being handled, if the
+// Bus that dispatched it has envelopes enabled.
+func EnvelopeFromContext(ctx context.Context) (*Envelope, bool) {
+	env, ok := ctx.Value(envelopeKey{}).(*Envelope)
+	return env, ok
+}
+
+// WithSource returns a copy of ctx that makes events dispatched with it carry
+// source as their Envelope.Source.
+func WithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// WithCorrelationID returns a copy of ctx that makes events dispatched with it
+// carry id as their Envelope.CorrelationID, overriding the one inherited from
+// the causing event.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// EnableEnvelopes enables envelopes on the default Bus.
+// See Bus.EnableEnvelopes for details.
+func EnableEnvelopes(clock temporal.Clock) {
+	defaultBus.EnableEnvelopes(clock)
+}
+
+// EnableEnvelopes makes the Bus attach an Envelope to every event it
+// dispatches, timestamped with clock. If clock is nil, the Bus uses
+// temporal.GetClock() at the time of each dispatch, which requires the flags
+// to have been parsed.
+func (b *Bus) EnableEnvelopes(clock temporal.Clock) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.envelopes = true
+	b.clock = clock
+}
+
+// seal returns a copy of ctx carrying a new Envelope, derived from the one of
+// the causing event if ctx has one.
+func seal(ctx context.Context, clock temporal.Clock) context.Context {
+	if clock == nil {
+		clock = temporal.GetClock()
+	}
+
+	env := &Envelope{ID: newID()}
+	// a failing clock leaves the time unset rather than losing the event
+	env.Time, _ = clock.Now()
+
+	if source, ok := ctx.Value(sourceKey{}).(string); ok {
+		env.Source = source
+	}
+	if cause, ok := EnvelopeFromContext(ctx); ok {
+		env.CausationID = cause.ID
+		env.CorrelationID = cause.CorrelationID
+	}
+	if id, ok := ctx.Value(correlationKey{}).(string); ok {
+		env.CorrelationID = id
+	}
+	if env.CorrelationID == "" {
+		env.CorrelationID = env.ID
+	}
+	return context.WithValue(ctx, envelopeKey{}, env)
+}
+
+// newID returns a random (version 4) UUID.
+func newID() string {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		panic(fmt.Sprintf("engine: can't generate event ID: %v", err))
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
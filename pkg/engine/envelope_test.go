@@ -0,0 +1,137 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/bhojpur/events/pkg/temporal"
+)
+
+func newTestClock(now time.Time) *temporal.TestClock {
+	clock := &temporal.TestClock{}
+	clock.Set(now)
+	return clock
+}
+
+func TestEnvelope(t *testing.T) {
+	b := NewBus()
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	b.EnableEnvelopes(newTestClock(now))
+
+	var env *Envelope
+	b.AddListener(func(ctx context.Context, ev testEvent1) {
+		env, _ = EnvelopeFromContext(ctx)
+	})
+	b.DispatchContext(WithSource(context.Background(), "test"), testEvent1{})
+
+	if env == nil {
+		t.Fatalf("listener got no envelope")
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(env.ID) {
+		t.Errorf("env.ID = %q, want a random UUID", env.ID)
+	}
+	if !env.Time.Earliest().Equal(now) || !env.Time.Latest().Equal(now) {
+		t.Errorf("env.Time = [%v, %v], want %v", env.Time.Earliest(), env.Time.Latest(), now)
+	}
+	if env.Source != "test" {
+		t.Errorf("env.Source = %q, want %q", env.Source, "test")
+	}
+	if env.CorrelationID != env.ID {
+		t.Errorf("env.CorrelationID = %q, want the event's ID %q", env.CorrelationID, env.ID)
+	}
+	if env.CausationID != "" {
+		t.Errorf("env.CausationID = %q, want none", env.CausationID)
+	}
+}
+
+func TestEnvelopeCausation(t *testing.T) {
+	b := NewBus()
+	b.EnableEnvelopes(newTestClock(time.Now()))
+
+	var cause, effect *Envelope
+	b.AddListener(func(ctx context.Context, ev testEvent1) {
+		cause, _ = EnvelopeFromContext(ctx)
+		b.DispatchContext(ctx, &testEvent2{})
+	})
+	b.AddListener(func(ctx context.Context, ev *testEvent2) {
+		effect, _ = EnvelopeFromContext(ctx)
+	})
+	b.DispatchContext(WithCorrelationID(context.Background(), "request-1"), testEvent1{})
+
+	if cause == nil || effect == nil {
+		t.Fatalf("listeners got envelopes %v and %v, want both", cause, effect)
+	}
+	if effect.ID == cause.ID {
+		t.Errorf("caused event has the same ID %q as its cause", effect.ID)
+	}
+	if effect.CausationID != cause.ID {
+		t.Errorf("effect.CausationID = %q, want %q", effect.CausationID, cause.ID)
+	}
+	if cause.CorrelationID != "request-1" || effect.CorrelationID != "request-1" {
+		t.Errorf("correlation IDs = %q and %q, want %q", cause.CorrelationID, effect.CorrelationID, "request-1")
+	}
+}
+
+func TestEnvelopeInterceptor(t *testing.T) {
+	b := NewBus()
+	b.EnableEnvelopes(newTestClock(time.Now()))
+
+	var seen *Envelope
+	b.Use(func(ctx context.Context, ev interface{}, next Handler) error {
+		seen, _ = EnvelopeFromContext(ctx)
+		return next(ctx, ev)
+	})
+	b.Dispatch(testEvent1{})
+
+	if seen == nil {
+		t.Errorf("interceptor got no envelope")
+	}
+}
+
+func TestEnvelopeDefaultClock(t *testing.T) {
+	b := NewBus()
+	b.EnableEnvelopes(nil)
+
+	var env *Envelope
+	b.AddListener(func(ctx context.Context, ev testEvent1) {
+		env, _ = EnvelopeFromContext(ctx)
+	})
+	b.Dispatch(testEvent1{})
+
+	if env == nil || !env.Time.IsValid() || env.Time.Earliest().IsZero() {
+		t.Errorf("listener got envelope %+v, want one timestamped by the default clock", env)
+	}
+}
+
+func TestEnvelopeDisabled(t *testing.T) {
+	b := NewBus()
+
+	b.AddListener(func(ctx context.Context, ev testEvent1) {
+		if env, ok := EnvelopeFromContext(ctx); ok {
+			t.Errorf("listener got envelope %+v from a Bus without envelopes", env)
+		}
+	})
+	b.Dispatch(testEvent1{})
+}
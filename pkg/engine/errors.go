@@ -0,0 +1,171 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/bhojpur/events/pkg/log"
+)
+
+var (
+	// errorType is the reflect.Type of the error interface.
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+	// contextType is the reflect.Type of the context.Context interface.
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// ListenerError describes a listener that returned an error or panicked while
+// handling an event.
+type ListenerError struct {
+	// Listener is the name of the listener function.
+	Listener string
+	// Event is the event the listener was handling.
+	Event interface{}
+	// Err is the error returned by the listener. If the listener panicked, it
+	// describes the panic value.
+	Err error
+	// Panic is the recovered panic value, or nil if the listener returned an
+	// error.
+	Panic interface{}
+	// Stack is the stack trace of the panicking goroutine, if any.
+	Stack []byte
+}
+
+func (e *ListenerError) Error() string {
+	return fmt.Sprintf("listener %s failed on %T: %v", e.Listener, e.Event, e.Err)
+}
+
+// Unwrap returns the error returned by the listener.
+func (e *ListenerError) Unwrap() error {
+	return e.Err
+}
+
+// ListenerErrors is returned by DispatchContext when synchronous listeners
+// failed to handle an event.
+type ListenerErrors []*ListenerError
+
+func (errs ListenerErrors) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+	msgs := make([]string, len(errs))
+	for i, lerr := range errs {
+		msgs[i] = lerr.Error()
+	}
+	return fmt.Sprintf("%d listeners failed: %s", len(errs), strings.Join(msgs, "; "))
+}
+
+// ListenerFailed is dispatched on a Bus after one of its listeners returned
+// an error or panicked, and after the Bus error handler has been called.
+// Failures of ListenerFailed listeners are only reported to the error handler.
+type ListenerFailed struct {
+	Err *ListenerError
+}
+
+// ErrorHandler is called with every listener failure on a Bus.
+type ErrorHandler func(*ListenerError)
+
+// SetErrorHandler sets the error handler of the default Bus.
+// See Bus.SetErrorHandler for details.
+func SetErrorHandler(h ErrorHandler) {
+	defaultBus.SetErrorHandler(h)
+}
+
+// SetErrorHandler sets the function that is called whenever a listener on the
+// Bus returns an error or panics. Passing nil restores the default handler,
+// which logs the failure.
+func (b *Bus) SetErrorHandler(h ErrorHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.errorHandler = h
+}
+
+// logListenerError is the default ErrorHandler.
+func logListenerError(lerr *ListenerError) {
+	if lerr.Panic != nil {
+		log.Errorf("%v\n%s", lerr, lerr.Stack)
+		return
+	}
+	log.Errorf("%v", lerr)
+}
+
+// reportError passes a listener failure to the error handler and dispatches
+// a ListenerFailed event for it.
+func (b *Bus) reportError(ctx context.Context, lerr *ListenerError) {
+	b.mu.RLock()
+	h := b.errorHandler
+	b.mu.RUnlock()
+
+	if h == nil {
+		h = logListenerError
+	}
+	h(lerr)
+
+	// don't report failures of ListenerFailed listeners recursively
+	if _, ok := lerr.Event.(ListenerFailed); !ok {
+		b.DispatchContext(ctx, ListenerFailed{Err: lerr})
+	}
+}
+
+// invoke calls the listener function, converting a returned error or a panic
+// into a ListenerError.
+func (l *listener) invoke(ctx context.Context, ev interface{}) (lerr *ListenerError) {
+	defer func() {
+		if r := recover(); r != nil {
+			lerr = newPanicError(l, ev, r)
+		}
+	}()
+
+	if err := l.handle(ctx, ev); err != nil {
+		return &ListenerError{
+			Listener: l.name,
+			Event:    ev,
+			Err:      err,
+		}
+	}
+	return nil
+}
+
+// newPanicError returns the ListenerError for a panic raised while l was
+// handling ev. It must be called by the deferred function recovering it.
+func newPanicError(l *listener, ev interface{}, r interface{}) *ListenerError {
+	return &ListenerError{
+		Listener: l.name,
+		Event:    ev,
+		Err:      fmt.Errorf("panic: %v", r),
+		Panic:    r,
+		Stack:    debug.Stack(),
+	}
+}
+
+// funcName returns the name of the function held by fn.
+func funcName(fn reflect.Value) string {
+	if f := runtime.FuncForPC(fn.Pointer()); f != nil {
+		return f.Name()
+	}
+	return fn.Type().String()
+}
@@ -0,0 +1,157 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPanickingListenerIsolated(t *testing.T) {
+	b := NewBus()
+
+	var failures []*ListenerError
+	b.SetErrorHandler(func(lerr *ListenerError) { failures = append(failures, lerr) })
+
+	triggered := false
+	b.AddListener(func(testEvent1) { panic("boom") })
+	b.AddListener(func(testEvent1) { triggered = true })
+	b.Dispatch(testEvent1{})
+
+	if !triggered {
+		t.Errorf("listener after panicking listener failed to trigger")
+	}
+	if len(failures) != 1 {
+		t.Fatalf("error handler called %d times, want 1", len(failures))
+	}
+	lerr := failures[0]
+	if lerr.Panic != "boom" {
+		t.Errorf("lerr.Panic = %#v, want %#v", lerr.Panic, "boom")
+	}
+	if len(lerr.Stack) == 0 {
+		t.Errorf("lerr.Stack is empty for a panicking listener")
+	}
+	if _, ok := lerr.Event.(testEvent1); !ok {
+		t.Errorf("lerr.Event = %#v, want a testEvent1", lerr.Event)
+	}
+	if !strings.Contains(lerr.Listener, "TestPanickingListenerIsolated") {
+		t.Errorf("lerr.Listener = %q, want the name of the listener func", lerr.Listener)
+	}
+}
+
+func TestErrorReturningListener(t *testing.T) {
+	b := NewBus()
+
+	errTest := errors.New("test error")
+	var failures []*ListenerError
+	b.SetErrorHandler(func(lerr *ListenerError) { failures = append(failures, lerr) })
+
+	b.AddListener(func(testEvent1) error { return errTest })
+	b.AddListener(func(testEvent1) error { return nil })
+	b.Dispatch(testEvent1{})
+
+	if len(failures) != 1 {
+		t.Fatalf("error handler called %d times, want 1", len(failures))
+	}
+	if !errors.Is(failures[0], errTest) {
+		t.Errorf("error handler got %v, want it to wrap %v", failures[0], errTest)
+	}
+	if failures[0].Panic != nil {
+		t.Errorf("lerr.Panic = %#v for a returned error, want nil", failures[0].Panic)
+	}
+}
+
+func TestListenerFailedDispatched(t *testing.T) {
+	b := NewBus()
+	b.SetErrorHandler(func(*ListenerError) {})
+
+	var failed []ListenerFailed
+	b.AddListener(func(ev ListenerFailed) { failed = append(failed, ev) })
+	b.AddListener(func(ListenerFailed) { panic("failing ListenerFailed listener") })
+	b.AddListener(func(testEvent1) { panic("boom") })
+	b.Dispatch(testEvent1{})
+
+	if len(failed) != 1 {
+		t.Fatalf("ListenerFailed dispatched %d times, want 1", len(failed))
+	}
+	if got := failed[0].Err.Panic; got != "boom" {
+		t.Errorf("ListenerFailed.Err.Panic = %#v, want %#v", got, "boom")
+	}
+}
+
+func TestDefaultErrorHandler(t *testing.T) {
+	b := NewBus()
+
+	called := false
+	b.SetErrorHandler(func(*ListenerError) { called = true })
+	b.SetErrorHandler(nil)
+
+	b.AddListener(func(testEvent1) error { return errors.New("logged error") })
+	b.Dispatch(testEvent1{})
+
+	if called {
+		t.Errorf("replaced error handler was called")
+	}
+}
+
+func TestAsyncPanickingListener(t *testing.T) {
+	b := NewBus(Async(1, Block))
+
+	failures := make(chan *ListenerError, 1)
+	b.SetErrorHandler(func(lerr *ListenerError) { failures <- lerr })
+	b.AddListener(func(testEvent1) { panic("boom") })
+	b.Dispatch(testEvent1{})
+	b.Close()
+
+	select {
+	case lerr := <-failures:
+		if lerr.Panic != "boom" {
+			t.Errorf("lerr.Panic = %#v, want %#v", lerr.Panic, "boom")
+		}
+	default:
+		t.Errorf("panic in asynchronous listener was not reported")
+	}
+}
+
+func TestListenerOtherResults(t *testing.T) {
+	b := NewBus()
+	var failures []*ListenerError
+	b.SetErrorHandler(func(lerr *ListenerError) { failures = append(failures, lerr) })
+
+	triggered := 0
+	b.AddListener(func(testEvent1) bool {
+		triggered++
+		return true
+	})
+	b.AddListener(func(testEvent1) (int, error) {
+		triggered++
+		return 1, errors.New("failed")
+	})
+	b.Dispatch(testEvent1{})
+
+	if triggered != 2 {
+		t.Errorf("%d listeners triggered, want 2", triggered)
+	}
+	if len(failures) != 1 || failures[0].Err.Error() != "failed" {
+		t.Errorf("failures = %v, want the error of the second listener", failures)
+	}
+}
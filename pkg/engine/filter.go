@@ -0,0 +1,179 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Filter makes a listener receive only the events for which pred returns
+// true. The Bus evaluates pred when the event is dispatched, with the value
+// the listener would be called with, and skips the listener if it returns
+// false; on an asynchronous Bus, skipped events are never queued. Multiple
+// filters on one listener must all pass. A panicking filter is reported like
+// a panicking listener, and the event is skipped.
+func Filter(pred func(ev interface{}) bool) ListenerOption {
+	return func(l *listener) {
+		l.filters = append(l.filters, pred)
+	}
+}
+
+// Where makes a listener receive only the events whose field equals value.
+// The field is given by name, and may be a dot-separated path through nested
+// structs or a field promoted from an embedded struct; pointers along the way
+// are followed. Numeric values are converted to the type of the field, so
+// that untyped constants can be used; a value the field's type can't
+// represent exactly, such as 1.5 for an int field, matches no event. Events
+// without the field, or with a nil pointer on the way to it, are skipped.
+//
+// For example, to only receive status updates for one multi-part event:
+//
+//	engine.AddListener(func(ev *MyEvent) {
+//	  ...
+//	}, engine.Where("EventID", id))
+//
+// AddListener panics with a BadListenerError if the listener's event type is
+// a struct, or a pointer to one, that does not have the field.
+func Where(field string, value interface{}) ListenerOption {
+	path := strings.Split(field, ".")
+	want := reflect.ValueOf(value)
+
+	return func(l *listener) {
+		if !hasField(l.evType, path) {
+			panic(BadListenerError(fmt.Sprintf("%v has no field %s", l.evType, field)))
+		}
+		l.filters = append(l.filters, func(ev interface{}) bool {
+			got, ok := fieldValue(reflect.ValueOf(ev), path)
+			return ok && fieldEquals(got, want)
+		})
+	}
+}
+
+// hasField reports whether t may have the field given by path. It only
+// returns false if t is known to lack it, i.e. if t is a struct (or a pointer
+// to one) without that field. A nil t, as for wildcard listeners, may have any
+// field.
+func hasField(t reflect.Type, path []string) bool {
+	if t == nil {
+		return true
+	}
+	for _, name := range path {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return t.Kind() == reflect.Interface
+		}
+		f, ok := t.FieldByName(name)
+		if !ok || !f.IsExported() {
+			return false
+		}
+		t = f.Type
+	}
+	return true
+}
+
+// fieldValue returns the field given by path of v, or false if it can't be
+// reached.
+func fieldValue(v reflect.Value, path []string) (reflect.Value, bool) {
+	for _, name := range path {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+		f, ok := v.Type().FieldByName(name)
+		if !ok || !f.IsExported() {
+			return reflect.Value{}, false
+		}
+		var err error
+		if v, err = v.FieldByIndexErr(f.Index); err != nil {
+			return reflect.Value{}, false
+		}
+	}
+	return v, true
+}
+
+// fieldEquals reports whether the field value got equals want.
+func fieldEquals(got, want reflect.Value) bool {
+	if !want.IsValid() {
+		// Where(field, nil)
+		switch got.Kind() {
+		case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+			return got.IsNil()
+		}
+		return false
+	}
+	if want.Type() != got.Type() {
+		if !isNumber(want.Kind()) || !isNumber(got.Kind()) || !want.CanConvert(got.Type()) {
+			return false
+		}
+		conv := want.Convert(got.Type())
+		// reject values that are truncated or wrap around
+		if isNegative(conv) != isNegative(want) ||
+			!reflect.DeepEqual(conv.Convert(want.Type()).Interface(), want.Interface()) {
+			return false
+		}
+		want = conv
+	}
+	return reflect.DeepEqual(got.Interface(), want.Interface())
+}
+
+// isNegative reports whether the number v is less than zero.
+func isNegative(v reflect.Value) bool {
+	switch {
+	case reflect.Int <= v.Kind() && v.Kind() <= reflect.Int64:
+		return v.Int() < 0
+	case v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64:
+		return v.Float() < 0
+	}
+	return false
+}
+
+// isNumber reports whether k is an integer or floating-point kind.
+func isNumber(k reflect.Kind) bool {
+	return reflect.Int <= k && k <= reflect.Float64
+}
+
+// accepts reports whether ev passes all filters of l. A panicking filter is
+// reported to the Bus like a panicking listener.
+func (b *Bus) accepts(ctx context.Context, l *listener, ev interface{}) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.reportError(ctx, newPanicError(l, ev, r))
+			ok = false
+		}
+	}()
+
+	for _, pred := range l.filters {
+		if !pred(ev) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,186 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testFilterEvent struct {
+	ID     int64
+	Name   string
+	Nested *testFilterEvent
+}
+
+func TestFilter(t *testing.T) {
+	b := NewBus()
+
+	var got []testAsyncEvent
+	b.AddListener(func(ev testAsyncEvent) { got = append(got, ev) },
+		Filter(func(ev interface{}) bool { return ev.(testAsyncEvent)%2 == 0 }),
+		Filter(func(ev interface{}) bool { return ev.(testAsyncEvent) > 2 }))
+	for i := 1; i <= 6; i++ {
+		b.Dispatch(testAsyncEvent(i))
+	}
+
+	if want := []testAsyncEvent{4, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listener got %v, want %v", got, want)
+	}
+}
+
+func TestWhere(t *testing.T) {
+	b := NewBus()
+
+	var got []string
+	b.AddListener(func(ev *testFilterEvent) { got = append(got, ev.Name) }, Where("ID", 2))
+	b.Dispatch(&testFilterEvent{ID: 1, Name: "one"})
+	b.Dispatch(&testFilterEvent{ID: 2, Name: "two"})
+	b.Dispatch((*testFilterEvent)(nil))
+
+	if want := []string{"two"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listener got %v, want %v", got, want)
+	}
+}
+
+func TestWhereConversion(t *testing.T) {
+	type numbers struct {
+		N int
+		U uint8
+		F float32
+	}
+	b := NewBus()
+
+	var got []string
+	match := func(name string) func(numbers) {
+		return func(numbers) { got = append(got, name) }
+	}
+	b.AddListener(match("N=1.0"), Where("N", 1.0))
+	b.AddListener(match("N=1.5"), Where("N", 1.5))
+	b.AddListener(match("U=300"), Where("U", 300))
+	b.AddListener(match("U=-1"), Where("U", -1))
+	b.AddListener(match("F=0.5"), Where("F", 0.5))
+	b.Dispatch(numbers{N: 1, U: 44})
+	b.Dispatch(numbers{U: 255, F: 0.5})
+
+	if want := []string{"N=1.0", "F=0.5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listeners called: %v, want %v", got, want)
+	}
+}
+
+func TestWhereNested(t *testing.T) {
+	b := NewBus()
+
+	var got []string
+	Subscribe(b, func(ev testFilterEvent) { got = append(got, ev.Name) }, Where("Nested.Name", "inner"))
+	Publish(b, testFilterEvent{Name: "no nested"})
+	Publish(b, testFilterEvent{Name: "other nested", Nested: &testFilterEvent{Name: "other"}})
+	Publish(b, testFilterEvent{Name: "match", Nested: &testFilterEvent{Name: "inner"}})
+
+	if want := []string{"match"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listener got %v, want %v", got, want)
+	}
+}
+
+func TestWhereNil(t *testing.T) {
+	b := NewBus()
+
+	var got []string
+	b.AddListener(func(ev testFilterEvent) { got = append(got, ev.Name) }, Where("Nested", nil))
+	b.Dispatch(testFilterEvent{Name: "nested", Nested: &testFilterEvent{}})
+	b.Dispatch(testFilterEvent{Name: "not nested"})
+
+	if want := []string{"not nested"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listener got %v, want %v", got, want)
+	}
+}
+
+func TestWherePromotedField(t *testing.T) {
+	b := NewBus()
+
+	var got []int
+	b.AddListener(func(ev interface{}) { got = append(got, ev.(*EmbeddingEvent).ID) }, Where("ID", 2))
+	b.Dispatch(&EmbeddingEvent{BaseEvent: BaseEvent{ID: 1}})
+	b.Dispatch(&EmbeddingEvent{BaseEvent: BaseEvent{ID: 2}})
+	b.Dispatch("no fields")
+
+	if want := []int{2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listener got IDs %v, want %v", got, want)
+	}
+}
+
+func TestWhereMissingField(t *testing.T) {
+	defer func() {
+		err := recover()
+
+		if err == nil {
+			t.Errorf("Where() on missing field failed to trigger panic")
+			return
+		}
+
+		blErr, ok := err.(BadListenerError)
+		if !ok {
+			panic(err) // this is not the error we were looking for; re-panic
+		}
+
+		want := "bad listener func: *engine.testFilterEvent has no field Missing"
+		if got := blErr.Error(); got != want {
+			t.Errorf(`BadListenerError.Error() = "%s", want "%s"`, got, want)
+		}
+	}()
+
+	NewBus().AddListener(func(*testFilterEvent) {}, Where("Missing", 1))
+}
+
+func TestFilterPanic(t *testing.T) {
+	b := NewBus()
+
+	var failures []*ListenerError
+	b.SetErrorHandler(func(lerr *ListenerError) { failures = append(failures, lerr) })
+	b.AddListener(func(testEvent1) { t.Errorf("listener triggered despite panicking filter") },
+		Filter(func(interface{}) bool { panic("boom") }))
+	b.Dispatch(testEvent1{})
+
+	if len(failures) != 1 || failures[0].Panic != "boom" {
+		t.Errorf("error handler got %v, want the panic of the filter", failures)
+	}
+}
+
+func TestFilterAsync(t *testing.T) {
+	b := NewBus(Async(1, DropNewest))
+	bl := newBlockingListener()
+	sub := b.AddListener(bl.listen, Filter(func(ev interface{}) bool { return ev.(testAsyncEvent) != 2 }))
+
+	b.Dispatch(testAsyncEvent(1))
+	<-bl.started
+	b.Dispatch(testAsyncEvent(2)) // filtered, not queued
+	b.Dispatch(testAsyncEvent(3)) // queued
+
+	close(bl.release)
+	b.Close()
+
+	if got, want := bl.events(), []testAsyncEvent{1, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	if got := sub.Dropped(); got != 0 {
+		t.Errorf("sub.Dropped() = %d, want 0", got)
+	}
+}
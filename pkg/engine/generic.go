@@ -0,0 +1,72 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"reflect"
+)
+
+// Subscribe registers fn on bus as a listener for events of type T, which may
+// be a concrete type or an interface. Unlike AddListener, the listener's shape
+// is checked at compile time, and it is called directly rather than through
+// reflection. Listeners registered with Subscribe and AddListener share the
+// same registry, so either kind receives events sent with Dispatch or
+// Publish, and opts apply as they do for AddListener.
+func Subscribe[T any](bus *Bus, fn func(T), opts ...ListenerOption) *Subscription {
+	return bus.register(&listener{
+		handle: func(_ context.Context, ev interface{}) error {
+			fn(ev.(T))
+			return nil
+		},
+		name:   funcName(reflect.ValueOf(fn)),
+		evType: typeOf[T](),
+	}, opts)
+}
+
+// SubscribeContext is like Subscribe, but fn also receives the context the
+// event was dispatched with, and may return an error that is passed to the
+// error handler of bus.
+func SubscribeContext[T any](bus *Bus, fn func(context.Context, T) error, opts ...ListenerOption) *Subscription {
+	return bus.register(&listener{
+		handle: func(ctx context.Context, ev interface{}) error {
+			return fn(ctx, ev.(T))
+		},
+		name:   funcName(reflect.ValueOf(fn)),
+		evType: typeOf[T](),
+	}, opts)
+}
+
+// Publish sends ev to all listeners on bus that accept its dynamic type or an
+// interface it implements. It is the type-safe equivalent of bus.Dispatch(ev).
+func Publish[T any](bus *Bus, ev T) {
+	bus.Dispatch(ev)
+}
+
+// PublishContext is the type-safe equivalent of bus.DispatchContext(ctx, ev).
+func PublishContext[T any](ctx context.Context, bus *Bus, ev T) error {
+	return bus.DispatchContext(ctx, ev)
+}
+
+// typeOf returns the reflect.Type of T, even if T is an interface type.
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
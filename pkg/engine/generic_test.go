@@ -0,0 +1,114 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	b := NewBus()
+
+	var got testAsyncEvent
+	Subscribe(b, func(ev testAsyncEvent) { got = ev })
+	Subscribe(b, func(testEvent1) { t.Errorf("wrong listener type triggered") })
+	Publish(b, testAsyncEvent(42))
+
+	if got != 42 {
+		t.Errorf("listener got %v, want 42", got)
+	}
+}
+
+func TestSubscribeInterface(t *testing.T) {
+	b := NewBus()
+
+	triggered := false
+	Subscribe(b, func(testInterface1) { triggered = true })
+	Subscribe(b, func(testInterface2) { t.Errorf("interface listener triggered on non-matching type") })
+	Publish(b, testEvent1{})
+
+	if !triggered {
+		t.Errorf("interface listener failed to trigger")
+	}
+}
+
+func TestPublishInterfaceValue(t *testing.T) {
+	b := NewBus()
+
+	triggered := false
+	Subscribe(b, func(*testEvent2) { triggered = true })
+
+	var ev testInterface2 = &testEvent2{}
+	Publish(b, ev)
+
+	if !triggered {
+		t.Errorf("listener for dynamic type failed to trigger")
+	}
+}
+
+func TestSubscribeInteroperability(t *testing.T) {
+	b := NewBus()
+
+	reflective, typed := 0, 0
+	b.AddListener(func(testEvent1) { reflective++ })
+	Subscribe(b, func(testInterface1) { typed++ })
+
+	b.Dispatch(testEvent1{})
+	Publish(b, testEvent1{})
+
+	if reflective != 2 || typed != 2 {
+		t.Errorf("listeners triggered (%d, %d) times, want (2, 2)", reflective, typed)
+	}
+}
+
+func TestSubscribeCancel(t *testing.T) {
+	b := NewBus()
+
+	count := 0
+	sub := Subscribe(b, func(testEvent1) { count++ })
+	Publish(b, testEvent1{})
+	sub.Cancel()
+	Publish(b, testEvent1{})
+
+	if count != 1 {
+		t.Errorf("cancelled listener triggered %d times, want 1", count)
+	}
+}
+
+func BenchmarkDispatchReflective(b *testing.B) {
+	bus := NewBus()
+	bus.AddListener(func(testAsyncEvent) {})
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		bus.Dispatch(testAsyncEvent(i))
+	}
+}
+
+func BenchmarkPublishTyped(b *testing.B) {
+	bus := NewBus()
+	Subscribe(bus, func(testAsyncEvent) {})
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Publish(bus, testAsyncEvent(i))
+	}
+}
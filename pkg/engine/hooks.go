@@ -21,38 +21,324 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"strings"
 	"sync"
+
+	"github.com/bhojpur/events/pkg/log"
 )
 
-// Hooks holds a list of parameter-less functions to call whenever the set is
-// triggered with Fire().
+// Hooks holds a list of functions to call whenever the set is triggered with
+// Fire() or FireContext(). The functions run concurrently, except that a
+// function declared to run After others waits until they have finished, so
+// that sequences such as a shutdown can be expressed as a dependency graph:
+//
+//	hooks.AddContext(stopServer, engine.HookName("grpc"))
+//	hooks.AddContext(drainStreams, engine.HookName("streams"), engine.After("grpc"))
+//	hooks.AddContext(flushLogs, engine.HookName("logs"), engine.After("streams"))
+//	hooks.AddContext(closeDB, engine.After("streams"))
+//
+// Here the logs are flushed while the database is closed.
 type Hooks struct {
-	funcs []func()
-	mu    sync.Mutex
+	hooks []hook
+	seq   uint64     // identifies hooks for removal
+	mu    sync.Mutex // protects hooks and seq
+
+	fireMu sync.Mutex // serializes Fire calls
 }
 
-// Add appends the given function to the list to be triggered.
-func (h *Hooks) Add(f func()) {
+// hook is a function registered with Hooks.
+type hook struct {
+	id    uint64
+	name  string
+	named bool     // set by HookName
+	after []string // see After
+	once  bool     // see Once
+	fn    func(context.Context) error
+}
+
+// HookHandle can remove a function added to Hooks.
+type HookHandle struct {
+	hooks *Hooks
+	id    uint64
+}
+
+// Remove removes the function from its Hooks, so that later calls of Fire
+// don't call it. Removing a function again has no effect.
+func (hh *HookHandle) Remove() {
+	h := hh.hooks
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	h.funcs = append(h.funcs, f)
+	h.remove(hh.id)
+}
+
+// remove removes the hook with the given id. h.mu must be held.
+func (h *Hooks) remove(id uint64) {
+	for i, hk := range h.hooks {
+		if hk.id == id {
+			// copy, since Fire may be iterating over the old slice
+			h.hooks = append(h.hooks[:i:i], h.hooks[i+1:]...)
+			return
+		}
+	}
+}
+
+// HookOption configures a function when it is added to Hooks.
+type HookOption func(*hook)
+
+// HookName names a hook function, so that other functions can run After it.
+// The name also identifies the function in HookErrors, instead of the name of
+// the Go function. Names given with HookName must be unique within a Hooks.
+func HookName(name string) HookOption {
+	return func(hk *hook) {
+		hk.name = name
+		hk.named = true
+	}
+}
+
+// After makes a hook function wait for the functions with the given names to
+// finish, whether or not they succeed. Names that no function has are
+// ignored when firing, so the functions named can be added later.
+func After(names ...string) HookOption {
+	return func(hk *hook) {
+		hk.after = append(hk.after, names...)
+	}
+}
+
+// HookError describes a hook that failed, panicked or didn't finish in time
+// when its Hooks were fired.
+type HookError struct {
+	// Hook is the name of the hook function.
+	Hook string
+	// Err is the error returned by the hook. If the hook panicked, it
+	// describes the panic value; if it was still running when the context
+	// passed to FireContext was done, it is the context's error.
+	Err error
+	// Panic is the recovered panic value, or nil if the hook didn't panic.
+	Panic interface{}
+	// Stack is the stack trace of the panicking goroutine, if any.
+	Stack []byte
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("hook %s failed: %v", e.Hook, e.Err)
+}
+
+// Unwrap returns the error of the hook.
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// HookErrors is returned by FireContext when hooks failed.
+type HookErrors []*HookError
+
+func (errs HookErrors) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+	msgs := make([]string, len(errs))
+	for i, herr := range errs {
+		msgs[i] = herr.Error()
+	}
+	return fmt.Sprintf("%d hooks failed: %s", len(errs), strings.Join(msgs, "; "))
+}
+
+// Once makes a hook function run only the next time its Hooks are fired; it
+// is removed as the firing starts.
+func Once() HookOption {
+	return func(hk *hook) {
+		hk.once = true
+	}
+}
+
+// Add appends the given function to the list to be triggered, and returns a
+// handle to remove it. Functions may add other functions while the list is
+// being fired; those run the next time.
+//
+// Add panics if the function is given a name that is already taken, or if it
+// would have to run after itself through After.
+func (h *Hooks) Add(f func(), opts ...HookOption) *HookHandle {
+	return h.add(funcName(reflect.ValueOf(f)), func(context.Context) error {
+		f()
+		return nil
+	}, opts)
+}
+
+// AddContext appends the given function to the list to be triggered. It
+// receives the context passed to FireContext, and should return early once
+// the context is done. It returns a handle and panics like Add.
+func (h *Hooks) AddContext(f func(ctx context.Context) error, opts ...HookOption) *HookHandle {
+	return h.add(funcName(reflect.ValueOf(f)), f, opts)
+}
+
+func (h *Hooks) add(name string, fn func(context.Context) error, opts []HookOption) *HookHandle {
+	hk := hook{name: name, fn: fn}
+	for _, opt := range opts {
+		opt(&hk)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if hk.named {
+		for _, other := range h.hooks {
+			if other.named && other.name == hk.name {
+				panic(fmt.Sprintf("engine: hook %q already added", hk.name))
+			}
+		}
+	}
+	hooks := append(h.hooks[:len(h.hooks):len(h.hooks)], hk)
+	if cycle := findCycle(hooks, len(hooks)-1); cycle != nil {
+		panic(fmt.Sprintf("engine: hook %q would run after itself: %s", hk.name, strings.Join(cycle, " after ")))
+	}
+	h.seq++
+	hooks[len(hooks)-1].id = h.seq
+	h.hooks = hooks
+	return &HookHandle{hooks: h, id: h.seq}
+}
+
+// findCycle returns the names along a dependency cycle through hooks[i], or
+// nil if there is none. Since the other hooks were checked when they were
+// added, any cycle goes through hooks[i].
+func findCycle(hooks []hook, i int) []string {
+	byName := indexHooks(hooks)
+	visited := make([]bool, len(hooks))
+
+	var path []string
+	var visit func(k int) bool
+	visit = func(k int) bool {
+		path = append(path, hooks[k].name)
+		for _, name := range hooks[k].after {
+			for _, d := range byName[name] {
+				if d == i {
+					path = append(path, hooks[i].name)
+					return true
+				}
+				if !visited[d] {
+					visited[d] = true
+					if visit(d) {
+						return true
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if visit(i) {
+		return path
+	}
+	return nil
+}
+
+// indexHooks maps the names of hooks to their indexes. Unnamed functions may
+// share a name.
+func indexHooks(hooks []hook) map[string][]int {
+	byName := make(map[string][]int, len(hooks))
+	for i, hk := range hooks {
+		byName[hk.name] = append(byName[hk.name], i)
+	}
+	return byName
 }
 
 // Fire calls all the functions in a given Hooks list. It launches a goroutine
 // for each function and then waits for all of them to finish before returning.
-// Concurrent calls to Fire() are serialized.
+// Concurrent calls to Fire() are serialized, but the list may be changed while
+// it is being fired. Failures are logged.
 func (h *Hooks) Fire() {
+	if err := h.FireContext(context.Background()); err != nil {
+		log.Errorf("%v", err)
+	}
+}
+
+// FireContext is like Fire, but passes ctx to the functions and stops
+// waiting for them when ctx is done. It returns the HookErrors describing the
+// functions that returned an error, panicked, or were still running or
+// waiting for others at that point, in the order they were added. Functions
+// still running are not stopped; they should watch ctx themselves.
+func (h *Hooks) FireContext(ctx context.Context) error {
+	h.fireMu.Lock()
+	defer h.fireMu.Unlock()
+
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	hooks := h.hooks
+	for _, hk := range hooks {
+		if hk.once {
+			h.remove(hk.id)
+		}
+	}
+	h.mu.Unlock()
+
+	byName := indexHooks(hooks)
+	done := make([]chan struct{}, len(hooks))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	results := make([]chan *HookError, len(hooks))
+	for i, hk := range hooks {
+		var deps []chan struct{}
+		for _, name := range hk.after {
+			for _, d := range byName[name] {
+				deps = append(deps, done[d])
+			}
+		}
+		results[i] = make(chan *HookError, 1)
+		go func(hk hook, deps []chan struct{}, result chan<- *HookError, done chan<- struct{}) {
+			defer close(done)
+			for _, dep := range deps {
+				select {
+				case <-dep:
+				case <-ctx.Done():
+					result <- &HookError{Hook: hk.name, Err: ctx.Err()}
+					return
+				}
+			}
+			result <- hk.run(ctx)
+		}(hk, deps, results[i], done[i])
+	}
+
+	var failed HookErrors
+	for i, result := range results {
+		var herr *HookError
+		select {
+		case herr = <-result:
+		case <-ctx.Done():
+			// collect the hooks that did finish in time
+			select {
+			case herr = <-result:
+			default:
+				herr = &HookError{Hook: hooks[i].name, Err: ctx.Err()}
+			}
+		}
+		if herr != nil {
+			failed = append(failed, herr)
+		}
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
 
-	wg := sync.WaitGroup{}
+// run calls the hook function, recovering a panic.
+func (hk hook) run(ctx context.Context) (herr *HookError) {
+	defer func() {
+		if r := recover(); r != nil {
+			herr = &HookError{
+				Hook:  hk.name,
+				Err:   fmt.Errorf("panic: %v", r),
+				Panic: r,
+				Stack: debug.Stack(),
+			}
+		}
+	}()
 
-	for _, f := range h.funcs {
-		wg.Add(1)
-		go func(f func()) {
-			f()
-			wg.Done()
-		}(f)
+	if err := hk.fn(ctx); err != nil {
+		return &HookError{Hook: hk.name, Err: err}
 	}
-	wg.Wait()
+	return nil
 }
@@ -21,7 +21,12 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // TestHooks checks that hooks get triggered.
@@ -39,3 +44,212 @@ func TestHooks(t *testing.T) {
 		t.Errorf("registered hook functions failed to trigger on Fire()")
 	}
 }
+
+func failingHook(ctx context.Context) error {
+	return errors.New("flush failed")
+}
+
+func hungHook(ctx context.Context) error {
+	<-ctx.Done()
+	time.Sleep(time.Second)
+	return nil
+}
+
+// TestFireContext checks that failures and timeouts are reported.
+func TestFireContext(t *testing.T) {
+	var hooks Hooks
+	hooks.AddContext(func(ctx context.Context) error { return nil })
+	hooks.AddContext(failingHook)
+	hooks.AddContext(hungHook)
+	hooks.Add(func() { panic("boom") })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := hooks.FireContext(ctx)
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("FireContext() took %v despite the deadline", d)
+	}
+
+	var failed HookErrors
+	if !errors.As(err, &failed) || len(failed) != 3 {
+		t.Fatalf("FireContext() = %v, want 3 failures", err)
+	}
+	if !strings.HasSuffix(failed[0].Hook, ".failingHook") || failed[0].Err.Error() != "flush failed" {
+		t.Errorf("failed[0] = %v", failed[0])
+	}
+	if !strings.HasSuffix(failed[1].Hook, ".hungHook") || !errors.Is(failed[1], context.DeadlineExceeded) {
+		t.Errorf("failed[1] = %v, want a timeout", failed[1])
+	}
+	if failed[2].Panic != "boom" || len(failed[2].Stack) == 0 {
+		t.Errorf("failed[2] = %v, want a panic", failed[2])
+	}
+	if !strings.HasPrefix(err.Error(), "3 hooks failed: hook ") {
+		t.Errorf("FireContext() error = %q", err)
+	}
+}
+
+// TestFireContextSuccess checks that nil is returned if all hooks succeed.
+func TestFireContextSuccess(t *testing.T) {
+	var hooks Hooks
+	if err := hooks.FireContext(context.Background()); err != nil {
+		t.Errorf("FireContext() without hooks = %v", err)
+	}
+	hooks.AddContext(func(ctx context.Context) error { return nil })
+	hooks.Add(func() {})
+	if err := hooks.FireContext(context.Background()); err != nil {
+		t.Errorf("FireContext() = %v", err)
+	}
+}
+
+// TestHooksOrder checks that hooks run after their dependencies, and
+// concurrently otherwise.
+func TestHooksOrder(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	record := func(name string, wait <-chan struct{}) func(context.Context) error {
+		return func(context.Context) error {
+			if wait != nil {
+				<-wait
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return nil
+		}
+	}
+
+	// "db" and "logs" both wait for "streams"; "logs" only finishes once
+	// "db" has recorded itself, which would deadlock if they ran
+	// sequentially in the order they were added
+	dbDone := make(chan struct{})
+	var hooks Hooks
+	hooks.AddContext(record("logs", dbDone), HookName("logs"), After("streams"))
+	hooks.AddContext(func(ctx context.Context) error {
+		defer close(dbDone)
+		return record("db", nil)(ctx)
+	}, HookName("db"), After("streams", "missing"))
+	hooks.AddContext(record("streams", nil), HookName("streams"), After("grpc"))
+	hooks.AddContext(func(context.Context) error { return errors.New("failed") }, HookName("grpc"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := hooks.FireContext(ctx)
+	var failed HookErrors
+	if !errors.As(err, &failed) || len(failed) != 1 || failed[0].Hook != "grpc" {
+		t.Errorf("FireContext() = %v, want hook grpc failed", err)
+	}
+	if len(order) != 3 || order[0] != "streams" || order[1] != "db" || order[2] != "logs" {
+		t.Errorf("hooks ran in order %v, want [streams db logs]", order)
+	}
+}
+
+// TestHooksWaitingTimeout checks that hooks waiting for a hung dependency
+// are reported as timed out.
+func TestHooksWaitingTimeout(t *testing.T) {
+	var hooks Hooks
+	hooks.AddContext(hungHook, HookName("hung"))
+	ran := false
+	hooks.Add(func() { ran = true }, HookName("next"), After("hung"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := hooks.FireContext(ctx)
+	var failed HookErrors
+	if !errors.As(err, &failed) || len(failed) != 2 || failed[1].Hook != "next" || !errors.Is(failed[1], context.DeadlineExceeded) {
+		t.Errorf("FireContext() = %v, want both hooks timed out", err)
+	}
+	if ran {
+		t.Errorf("hook ran although its dependency didn't finish")
+	}
+}
+
+// TestHooksBadRegistration checks that cycles and duplicate names are
+// rejected when hooks are added.
+func TestHooksBadRegistration(t *testing.T) {
+	var hooks Hooks
+	hooks.Add(func() {}, HookName("a"), After("c"))
+	hooks.Add(func() {}, HookName("b"), After("a"))
+
+	for _, tc := range []struct {
+		name string
+		opts []HookOption
+		want string
+	}{
+		{"cycle", []HookOption{HookName("c"), After("b")}, `engine: hook "c" would run after itself: c after b after a after c`},
+		{"self", []HookOption{HookName("d"), After("d")}, `engine: hook "d" would run after itself: d after d`},
+		{"duplicate", []HookOption{HookName("a")}, `engine: hook "a" already added`},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r != tc.want {
+					t.Errorf("%s: Add() panicked with %v, want %q", tc.name, r, tc.want)
+				}
+			}()
+			hooks.Add(func() {}, tc.opts...)
+		}()
+	}
+
+	// the rejected hooks were not added
+	if err := hooks.FireContext(context.Background()); err != nil {
+		t.Errorf("FireContext() = %v", err)
+	}
+}
+
+// TestHooksRemove checks that removed hooks are not triggered.
+func TestHooksRemove(t *testing.T) {
+	var hooks Hooks
+	calls := 0
+	handle := hooks.Add(func() { calls++ })
+	hooks.Fire()
+	handle.Remove()
+	handle.Remove()
+	hooks.Fire()
+	if calls != 1 {
+		t.Errorf("hook called %d times, want 1", calls)
+	}
+}
+
+// TestHooksOnce checks that one-shot hooks are only triggered once.
+func TestHooksOnce(t *testing.T) {
+	var hooks Hooks
+	once, always := 0, 0
+	hooks.Add(func() { once++ }, Once())
+	hooks.Add(func() { always++ })
+	hooks.Fire()
+	hooks.Fire()
+	if once != 1 || always != 2 {
+		t.Errorf("hooks called %d and %d times, want 1 and 2", once, always)
+	}
+}
+
+// TestHooksReentrant checks that hooks can add and remove hooks while
+// firing.
+func TestHooksReentrant(t *testing.T) {
+	var hooks Hooks
+	added := 0
+	var self *HookHandle
+	self = hooks.Add(func() {
+		hooks.Add(func() { added++ })
+		self.Remove()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		hooks.Fire()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Fire() deadlocked")
+	}
+	if added != 0 {
+		t.Errorf("hook added while firing ran in the same Fire()")
+	}
+
+	hooks.Fire()
+	if added != 1 {
+		t.Errorf("added hook called %d times, want 1", added)
+	}
+}
@@ -0,0 +1,72 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+)
+
+// Handler delivers an event, see Interceptor.
+type Handler func(ctx context.Context, ev interface{}) error
+
+// Interceptor wraps the delivery of every event dispatched on a Bus, much
+// like a gRPC unary server interceptor. It is called with the event and the
+// next Handler in the chain, and can:
+//
+//   - observe the event, and the result returned by next;
+//   - mutate it, by passing a different event or context to next;
+//   - veto it, by returning without calling next.
+//
+// The innermost Handler calls the listeners and returns the same result as
+// DispatchContext. Whatever the outermost Interceptor returns is returned by
+// DispatchContext.
+type Interceptor func(ctx context.Context, ev interface{}, next Handler) error
+
+// Use appends interceptors to the chain of the default Bus.
+// See Bus.Use for details.
+func Use(interceptors ...Interceptor) {
+	defaultBus.Use(interceptors...)
+}
+
+// Use appends interceptors to the chain wrapping every dispatch on the Bus.
+// The first interceptor added is the outermost one, i.e. it sees the event
+// first and the result last. Events are passed through the chain by
+// DispatchContext (and thus Dispatch and DispatchUpdate), including the
+// ListenerFailed events the Bus dispatches itself.
+func (b *Bus) Use(interceptors ...Interceptor) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.interceptors = append(b.interceptors, interceptors...)
+	b.handler = chainInterceptors(b.interceptors, b.deliver)
+}
+
+// chainInterceptors returns a Handler passing events through interceptors
+// before handing them to h.
+func chainInterceptors(interceptors []Interceptor, h Handler) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		ic, next := interceptors[i], h
+		h = func(ctx context.Context, ev interface{}) error {
+			return ic(ctx, ev, next)
+		}
+	}
+	return h
+}
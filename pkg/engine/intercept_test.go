@@ -0,0 +1,150 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestInterceptorOrder(t *testing.T) {
+	b := NewBus()
+
+	var order []string
+	record := func(name string) Interceptor {
+		return func(ctx context.Context, ev interface{}, next Handler) error {
+			order = append(order, name+" before")
+			err := next(ctx, ev)
+			order = append(order, name+" after")
+			return err
+		}
+	}
+	b.Use(record("outer"))
+	b.Use(record("inner"))
+	b.AddListener(func(testEvent1) { order = append(order, "listener") })
+	b.Dispatch(testEvent1{})
+
+	want := []string{"outer before", "inner before", "listener", "inner after", "outer after"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("called in order %v, want %v", order, want)
+	}
+}
+
+func TestInterceptorVeto(t *testing.T) {
+	b := NewBus()
+
+	errVetoed := errors.New("vetoed")
+	b.Use(func(ctx context.Context, ev interface{}, next Handler) error {
+		if _, ok := ev.(testEvent1); ok {
+			return errVetoed
+		}
+		return next(ctx, ev)
+	})
+	b.AddListener(func(testEvent1) { t.Errorf("listener triggered for vetoed event") })
+
+	triggered := false
+	b.AddListener(func(*testEvent2) { triggered = true })
+
+	if err := b.DispatchContext(context.Background(), testEvent1{}); err != errVetoed {
+		t.Errorf("DispatchContext() = %v, want %v", err, errVetoed)
+	}
+	if err := b.DispatchContext(context.Background(), &testEvent2{}); err != nil {
+		t.Errorf("DispatchContext() = %v, want nil", err)
+	}
+	if !triggered {
+		t.Errorf("listener for event passed by interceptor failed to trigger")
+	}
+}
+
+func TestInterceptorMutate(t *testing.T) {
+	b := NewBus()
+
+	b.Use(func(ctx context.Context, ev interface{}, next Handler) error {
+		if n, ok := ev.(testAsyncEvent); ok {
+			ev = n * 2
+		}
+		return next(context.WithValue(ctx, testContextKey{}, "intercepted"), ev)
+	})
+
+	var got testAsyncEvent
+	var value interface{}
+	b.AddListener(func(ctx context.Context, ev testAsyncEvent) {
+		got = ev
+		value = ctx.Value(testContextKey{})
+	})
+	b.Dispatch(testAsyncEvent(21))
+
+	if got != 42 {
+		t.Errorf("listener got %v, want 42", got)
+	}
+	if value != "intercepted" {
+		t.Errorf("listener got context value %#v, want %#v", value, "intercepted")
+	}
+}
+
+func TestInterceptorObservesFailures(t *testing.T) {
+	b := NewBus()
+	b.SetErrorHandler(func(*ListenerError) {})
+
+	var observed error
+	b.Use(func(ctx context.Context, ev interface{}, next Handler) error {
+		err := next(ctx, ev)
+		if _, ok := ev.(testEvent1); ok {
+			observed = err
+		}
+		return err
+	})
+
+	errTest := errors.New("test error")
+	b.AddListener(func(testEvent1) error { return errTest })
+	b.AddListener(func(testEvent1) { panic("boom") })
+	b.AddListener(func(testEvent1) {})
+	err := b.DispatchContext(context.Background(), testEvent1{})
+
+	lerrs, ok := observed.(ListenerErrors)
+	if !ok || len(lerrs) != 2 {
+		t.Fatalf("interceptor observed %v, want ListenerErrors of 2 failures", observed)
+	}
+	if !errors.Is(lerrs[0], errTest) || lerrs[1].Panic != "boom" {
+		t.Errorf("interceptor observed %v, want the error and the panic of the failing listeners", lerrs)
+	}
+	if !reflect.DeepEqual(err, observed) {
+		t.Errorf("DispatchContext() = %v, want %v", err, observed)
+	}
+}
+
+func TestDefaultBusUse(t *testing.T) {
+	clearListeners()
+	defer clearListeners()
+
+	count := 0
+	Use(func(ctx context.Context, ev interface{}, next Handler) error {
+		count++
+		return next(ctx, ev)
+	})
+	Dispatch(testEvent1{})
+
+	if count != 1 {
+		t.Errorf("interceptor called %d times, want 1", count)
+	}
+}
@@ -0,0 +1,59 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+
+	"github.com/bhojpur/events/pkg/log"
+)
+
+// Journal records events dispatched on a Bus, see Journaled. Package
+// github.com/bhojpur/events/pkg/engine/journal provides an implementation
+// storing them on local disk.
+type Journal interface {
+	// Append records ev, together with its Envelope if ctx carries one.
+	Append(ctx context.Context, ev interface{}) error
+}
+
+// Journaled returns an Interceptor that appends every event reaching it to j
+// before passing it on. Events that can't be appended are still delivered,
+// and the failure is logged. Since interceptors run in the order they were
+// added, the journal only sees events that earlier interceptors let through,
+// as modified by them. Replayed events (see IsReplay) are not appended again.
+//
+// For example, to record every event dispatched on the default Bus:
+//
+//	j, err := journal.Open(dir, journal.Options{Codec: codec})
+//	...
+//	engine.Use(engine.Journaled(j))
+func Journaled(j Journal) Interceptor {
+	return func(ctx context.Context, ev interface{}, next Handler) error {
+		if IsReplay(ctx) {
+			// it was journaled when it was first dispatched
+			return next(ctx, ev)
+		}
+		if err := j.Append(ctx, ev); err != nil {
+			log.Errorf("can't journal %T event: %v", ev, err)
+		}
+		return next(ctx, ev)
+	}
+}
@@ -0,0 +1,503 @@
+package journal
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+/*
+Package journal stores the events dispatched through the Events engine package
+in an append-only log on local disk, so that they survive the process.
+
+Every appended event gets a sequential offset, starting at zero, and is
+serialized with the EventCodec given in the Options together with its
+envelope, if any. Records are written to segment files in the journal's
+directory; a new segment is started once the current one exceeds the
+configured size. Each segment is named after the offset of its first record.
+
+To journal every event dispatched on a Bus, install the journal as an
+interceptor:
+
+	j, err := journal.Open("/var/lib/events", journal.Options{Codec: codec})
+	if err != nil {
+		...
+	}
+	defer j.Close()
+	bus.Use(engine.Journaled(j))
+
+Use NewReader to iterate over the journal from a given offset, while it is
+being written or offline.
+*/
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhojpur/events/pkg/engine"
+	"github.com/bhojpur/events/pkg/log"
+	"github.com/bhojpur/events/pkg/temporal"
+)
+
+// SyncPolicy decides when appended records are flushed to stable storage
+// with fsync.
+type SyncPolicy int
+
+const (
+	// SyncEveryAppend flushes every record before Append returns.
+	SyncEveryAppend SyncPolicy = iota
+	// SyncPeriodic flushes records in the background every
+	// Options.SyncInterval.
+	SyncPeriodic
+	// SyncNone leaves flushing to the operating system, except when a
+	// segment is completed and when the journal is closed.
+	SyncNone
+)
+
+const (
+	// DefaultSegmentSize is the segment size used if Options.SegmentSize is
+	// not set.
+	DefaultSegmentSize = 64 << 20
+	// DefaultSyncInterval is the interval used with SyncPeriodic if
+	// Options.SyncInterval is not set.
+	DefaultSyncInterval = time.Second
+	// MaxRecordSize is the largest serialized record, in bytes, that a
+	// journal accepts. Longer records are reported as corrupt when read.
+	MaxRecordSize = 64 << 20
+
+	// segmentExt is the file name extension of segments.
+	segmentExt = ".journal"
+	// headerSize is the size of the length and checksum preceding each
+	// record.
+	headerSize = 8
+)
+
+// ErrCorrupt is returned when a journal contains a damaged record.
+var ErrCorrupt = errors.New("journal: corrupt record")
+
+// Options configure a Journal.
+type Options struct {
+	// Codec serializes the appended events, typically an engine.Registry. It
+	// is required.
+	Codec engine.EventCodec
+	// SegmentSize is the size in bytes after which a new segment is
+	// started. It defaults to DefaultSegmentSize.
+	SegmentSize int64
+	// Sync is the policy for flushing records to stable storage.
+	Sync SyncPolicy
+	// SyncInterval is the flush interval for SyncPeriodic. It defaults to
+	// DefaultSyncInterval.
+	SyncInterval time.Duration
+}
+
+// Record is an event read back from a journal.
+type Record struct {
+	// Offset is the position of the record in the journal.
+	Offset uint64
+	// Envelope is the envelope the event was dispatched with, or the zero
+	// Envelope if it had none.
+	Envelope engine.Envelope
+	// Event is the serialized event; decode it with the codec it was
+	// written with.
+	Event engine.EncodedEvent
+}
+
+// record is the serialized form of a Record.
+type record struct {
+	Offset        uint64 `json:"offset"`
+	ID            string `json:"id,omitempty"`
+	Earliest      int64  `json:"earliest,omitempty"`
+	Latest        int64  `json:"latest,omitempty"`
+	Source        string `json:"source,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	CausationID   string `json:"causation_id,omitempty"`
+	Type          string `json:"type"`
+	Version       int    `json:"version,omitempty"`
+	Data          []byte `json:"data"`
+}
+
+func (r *record) toRecord() Record {
+	rec := Record{
+		Offset: r.Offset,
+		Envelope: engine.Envelope{
+			ID:            r.ID,
+			Source:        r.Source,
+			CorrelationID: r.CorrelationID,
+			CausationID:   r.CausationID,
+		},
+		Event: engine.EncodedEvent{Type: r.Type, Version: r.Version, Data: r.Data},
+	}
+	if r.Earliest != 0 || r.Latest != 0 {
+		// only valid intervals are ever written
+		rec.Envelope.Time, _ = temporal.NewInterval(time.Unix(0, r.Earliest), time.Unix(0, r.Latest))
+	}
+	return rec
+}
+
+// Journal is an append-only, segmented event log in a local directory. It
+// implements engine.Journal and is safe for concurrent use.
+type Journal struct {
+	dir  string
+	opts Options
+
+	mu     sync.Mutex // protects the fields below
+	f      *os.File   // current segment
+	size   int64      // size of the current segment
+	next   uint64     // offset of the next record
+	closed bool
+	err    error // set if the journal can no longer be appended to
+
+	stop     chan struct{} // stops periodic syncing
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+var _ engine.Journal = (*Journal)(nil) // compile-time interface check
+
+// Open opens the journal in dir, creating the directory if needed, and
+// prepares it for appending after its last record. A record left incomplete
+// or damaged by a crash at the end of the last segment is discarded; a
+// damaged record followed by others is reported as ErrCorrupt.
+func Open(dir string, opts Options) (*Journal, error) {
+	if opts.Codec == nil {
+		return nil, errors.New("journal: no codec")
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	j := &Journal{dir: dir, opts: opts}
+
+	bases, err := segments(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(bases) == 0 {
+		if err := j.createSegment(0); err != nil {
+			return nil, err
+		}
+	} else if err := j.recover(bases[len(bases)-1]); err != nil {
+		return nil, err
+	}
+
+	if opts.Sync == SyncPeriodic {
+		j.stop = make(chan struct{})
+		j.stopped = make(chan struct{})
+		go j.syncPeriodically()
+	}
+	return j, nil
+}
+
+// recover opens the segment starting at base for appending, after its last
+// complete record.
+func (j *Journal) recover(base uint64) error {
+	f, err := os.OpenFile(segmentPath(j.dir, base), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+
+	next, size := base, int64(0)
+	r := bufio.NewReader(f)
+	for {
+		rec, n, err := readRecord(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if errors.Is(err, ErrCorrupt) {
+			// only the last record can have been damaged by a crash
+			last, lerr := lastRecord(f, size)
+			if lerr == nil && !last {
+				lerr = fmt.Errorf("%s: record at byte %d: %w", f.Name(), size, err)
+			}
+			if lerr != nil {
+				f.Close()
+				return lerr
+			}
+			log.Errorf("discarding damaged record at the end of %s: %v", f.Name(), err)
+			break
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+		next, size = rec.Offset+1, size+n
+	}
+
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	j.f, j.size, j.next = f, size, next
+	return nil
+}
+
+// lastRecord reports whether the record at pos in f extends to the end of f,
+// according to its header.
+func lastRecord(f *os.File, pos int64) (bool, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	var header [headerSize]byte
+	if _, err := f.ReadAt(header[:], pos); err != nil {
+		return false, err
+	}
+	return pos+headerSize+int64(binary.BigEndian.Uint32(header[0:4])) >= fi.Size(), nil
+}
+
+// createSegment starts a new segment whose first record has offset base.
+func (j *Journal) createSegment(base uint64) error {
+	f, err := os.OpenFile(segmentPath(j.dir, base), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	j.f, j.size, j.next = f, 0, base
+	return nil
+}
+
+// Append serializes ev and appends it to the journal, together with its
+// envelope if ctx carries one.
+func (j *Journal) Append(ctx context.Context, ev interface{}) error {
+	enc, err := j.opts.Codec.Encode(ev)
+	if err != nil {
+		return err
+	}
+	r := &record{Type: enc.Type, Version: enc.Version, Data: enc.Data}
+	if env, ok := engine.EnvelopeFromContext(ctx); ok {
+		r.ID = env.ID
+		r.Source = env.Source
+		r.CorrelationID = env.CorrelationID
+		r.CausationID = env.CausationID
+		if env.Time.IsValid() && !env.Time.Earliest().IsZero() {
+			r.Earliest = env.Time.Earliest().UnixNano()
+			r.Latest = env.Time.Latest().UnixNano()
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.closed {
+		return errors.New("journal: append to closed journal")
+	}
+	if j.err != nil {
+		return j.err
+	}
+
+	r.Offset = j.next
+	buf, err := encodeRecord(r)
+	if err != nil {
+		return err
+	}
+
+	if j.size > 0 && j.size+int64(len(buf)) > j.opts.SegmentSize {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := j.f.Write(buf); err != nil {
+		// drop what was written of the record, so that later records don't
+		// end up behind it
+		if terr := j.truncate(); terr != nil {
+			j.err = fmt.Errorf("journal: can't discard partial record: %v", terr)
+		}
+		return err
+	}
+	j.size += int64(len(buf))
+	j.next++
+
+	if j.opts.Sync == SyncEveryAppend {
+		return j.f.Sync()
+	}
+	return nil
+}
+
+// truncate cuts the current segment back to j.size.
+// j.mu must be held.
+func (j *Journal) truncate() error {
+	if err := j.f.Truncate(j.size); err != nil {
+		return err
+	}
+	_, err := j.f.Seek(j.size, io.SeekStart)
+	return err
+}
+
+// rotate completes the current segment and starts a new one.
+// j.mu must be held.
+func (j *Journal) rotate() error {
+	if err := j.f.Sync(); err != nil {
+		return err
+	}
+	if err := j.f.Close(); err != nil {
+		return err
+	}
+	return j.createSegment(j.next)
+}
+
+// NextOffset returns the offset the next appended event will get.
+func (j *Journal) NextOffset() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.next
+}
+
+// Sync flushes all appended records to stable storage.
+func (j *Journal) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return nil
+	}
+	return j.f.Sync()
+}
+
+func (j *Journal) syncPeriodically() {
+	defer close(j.stopped)
+
+	ticker := time.NewTicker(j.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := j.Sync(); err != nil {
+				log.Errorf("can't sync journal %s: %v", j.dir, err)
+			}
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// Close flushes and closes the journal. Further calls do nothing.
+func (j *Journal) Close() error {
+	j.stopOnce.Do(func() {
+		if j.stop != nil {
+			close(j.stop)
+			<-j.stopped
+		}
+	})
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return nil
+	}
+	j.closed = true
+
+	if err := j.f.Sync(); err != nil {
+		j.f.Close()
+		return err
+	}
+	return j.f.Close()
+}
+
+// encodeRecord frames r as its length and CRC-32 checksum, followed by its
+// JSON encoding.
+func encodeRecord(r *record) ([]byte, error) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > MaxRecordSize {
+		return nil, fmt.Errorf("journal: record of %d bytes exceeds MaxRecordSize", len(payload))
+	}
+	buf := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[headerSize:], payload)
+	return buf, nil
+}
+
+// readRecord reads the next record from r and returns it with its size on
+// disk. It returns io.EOF at the end of r, and io.ErrUnexpectedEOF if r ends
+// within a record.
+func readRecord(r io.Reader) (*record, int64, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size > MaxRecordSize {
+		return nil, 0, ErrCorrupt
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, ErrCorrupt
+	}
+
+	rec := new(record)
+	if err := json.Unmarshal(payload, rec); err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return rec, int64(headerSize + len(payload)), nil
+}
+
+// segments returns the base offsets of the segments in dir, in order.
+func segments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var bases []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		bases = append(bases, base)
+	}
+	sort.Slice(bases, func(i, k int) bool { return bases[i] < bases[k] })
+	return bases, nil
+}
+
+// segmentPath returns the path of the segment starting at offset base.
+func segmentPath(dir string, base uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", base, segmentExt))
+}
@@ -0,0 +1,397 @@
+package journal
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bhojpur/events/pkg/engine"
+	"github.com/bhojpur/events/pkg/temporal"
+)
+
+type testEvent struct {
+	N int
+}
+
+// testCodec serializes testEvents as JSON.
+type testCodec struct{}
+
+func (testCodec) Encode(ev interface{}) (engine.EncodedEvent, error) {
+	data, err := json.Marshal(ev)
+	return engine.EncodedEvent{Type: fmt.Sprintf("%T", ev), Version: 2, Data: data}, err
+}
+
+func (testCodec) Decode(enc engine.EncodedEvent) (interface{}, error) {
+	var ev testEvent
+	err := json.Unmarshal(enc.Data, &ev)
+	return ev, err
+}
+
+func openTest(t *testing.T, dir string, segmentSize int64) *Journal {
+	t.Helper()
+	j, err := Open(dir, Options{Codec: testCodec{}, SegmentSize: segmentSize})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return j
+}
+
+func appendN(t *testing.T, j *Journal, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := j.Append(context.Background(), testEvent{i}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+}
+
+// readAll returns the events of the records in dir from offset from, and
+// checks their offsets are sequential.
+func readAll(t *testing.T, dir string, from uint64) []int {
+	t.Helper()
+	rd, err := NewReader(dir, from)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	defer rd.Close()
+
+	var got []int
+	for off := from; ; off++ {
+		rec, err := rd.Next()
+		if err == io.EOF {
+			return got
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if rec.Offset != off {
+			t.Errorf("rec.Offset = %d, want %d", rec.Offset, off)
+		}
+		ev, err := testCodec{}.Decode(rec.Event)
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		got = append(got, ev.(testEvent).N)
+	}
+}
+
+func checkEvents(t *testing.T, got []int, from, to int) {
+	t.Helper()
+	if len(got) != to-from {
+		t.Fatalf("read %d events, want %d", len(got), to-from)
+	}
+	for i, n := range got {
+		if n != from+i {
+			t.Errorf("event %d = %d, want %d", i, n, from+i)
+		}
+	}
+}
+
+func TestAppendRead(t *testing.T) {
+	dir := t.TempDir()
+	j := openTest(t, dir, 0)
+	defer j.Close()
+
+	appendN(t, j, 0, 10)
+	if j.NextOffset() != 10 {
+		t.Errorf("NextOffset() = %d, want 10", j.NextOffset())
+	}
+	checkEvents(t, readAll(t, dir, 0), 0, 10)
+	checkEvents(t, readAll(t, dir, 4), 4, 10)
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	j := openTest(t, dir, 100)
+	defer j.Close()
+
+	appendN(t, j, 0, 20)
+	bases, err := segments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bases) < 2 {
+		t.Fatalf("got %d segments, want several", len(bases))
+	}
+	checkEvents(t, readAll(t, dir, 0), 0, 20)
+	checkEvents(t, readAll(t, dir, 13), 13, 20)
+}
+
+func TestReaderFollows(t *testing.T) {
+	dir := t.TempDir()
+	j := openTest(t, dir, 100)
+	defer j.Close()
+
+	rd, err := NewReader(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+
+	for i := 0; i < 10; i++ {
+		if _, err := rd.Next(); err != io.EOF {
+			t.Fatalf("Next() error = %v, want io.EOF", err)
+		}
+		appendN(t, j, i, i+1)
+		rec, err := rd.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if rec.Offset != uint64(i) {
+			t.Errorf("rec.Offset = %d, want %d", rec.Offset, i)
+		}
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	j := openTest(t, dir, 100)
+	appendN(t, j, 0, 10)
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// simulate a crash in the middle of writing a record
+	bases, _ := segments(dir)
+	f, err := os.OpenFile(segmentPath(dir, bases[len(bases)-1]), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 42, 1, 2})
+	f.Close()
+
+	j = openTest(t, dir, 100)
+	defer j.Close()
+	if j.NextOffset() != 10 {
+		t.Errorf("NextOffset() = %d, want 10", j.NextOffset())
+	}
+	appendN(t, j, 10, 15)
+	checkEvents(t, readAll(t, dir, 0), 0, 15)
+}
+
+func TestReopenCorruptLength(t *testing.T) {
+	dir := t.TempDir()
+	j := openTest(t, dir, 0)
+	appendN(t, j, 0, 3)
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// a damaged header must not be taken for a 4 GiB record
+	f, err := os.OpenFile(segmentPath(dir, 0), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	f.Close()
+
+	j = openTest(t, dir, 0)
+	defer j.Close()
+	if j.NextOffset() != 3 {
+		t.Errorf("NextOffset() = %d, want 3", j.NextOffset())
+	}
+	appendN(t, j, 3, 5)
+	checkEvents(t, readAll(t, dir, 0), 0, 5)
+}
+
+// flipByte corrupts the byte at pos in the segment starting at base.
+func flipByte(t *testing.T, dir string, base uint64, pos int64) {
+	t.Helper()
+	f, err := os.OpenFile(segmentPath(dir, base), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, pos); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if _, err := f.WriteAt(b, pos); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReopenCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	j := openTest(t, dir, 0)
+	appendN(t, j, 0, 10)
+	j.Close()
+
+	// damage record 1, which is followed by valid records
+	fi, err := os.Stat(segmentPath(dir, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipByte(t, dir, 0, fi.Size()/10+headerSize+2)
+
+	if _, err := Open(dir, Options{Codec: testCodec{}}); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Open() error = %v, want %v", err, ErrCorrupt)
+	}
+	if fi2, _ := os.Stat(segmentPath(dir, 0)); fi2.Size() != fi.Size() {
+		t.Errorf("segment truncated from %d to %d bytes", fi.Size(), fi2.Size())
+	}
+}
+
+func TestReopenDamagedLastRecord(t *testing.T) {
+	dir := t.TempDir()
+	j := openTest(t, dir, 0)
+	appendN(t, j, 0, 10)
+	j.Close()
+
+	fi, err := os.Stat(segmentPath(dir, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipByte(t, dir, 0, fi.Size()-2)
+
+	j = openTest(t, dir, 0)
+	defer j.Close()
+	if j.NextOffset() != 9 {
+		t.Errorf("NextOffset() = %d, want 9", j.NextOffset())
+	}
+	appendN(t, j, 9, 12)
+	checkEvents(t, readAll(t, dir, 0), 0, 12)
+}
+
+func TestReaderPartialRecordInCompletedSegment(t *testing.T) {
+	dir := t.TempDir()
+	j := openTest(t, dir, 200)
+	appendN(t, j, 0, 10)
+	j.Close()
+
+	bases, _ := segments(dir)
+	if len(bases) < 2 {
+		t.Fatalf("journal has %d segments, want several", len(bases))
+	}
+	path := segmentPath(dir, bases[0])
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, fi.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := NewReader(dir, 0)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	defer rd.Close()
+	done := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := rd.Next(); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-done:
+		if err != ErrCorrupt {
+			t.Errorf("Next() error = %v, want %v", err, ErrCorrupt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Next() doesn't return")
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	j, err := Open(t.TempDir(), Options{Codec: testCodec{}, Sync: SyncPeriodic})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := j.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if err := j.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestOpenWithoutCodec(t *testing.T) {
+	if _, err := Open(t.TempDir(), Options{}); err == nil {
+		t.Errorf("Open without a codec succeeded")
+	}
+}
+
+func TestJournaled(t *testing.T) {
+	dir := t.TempDir()
+	j := openTest(t, dir, 0)
+	defer j.Close()
+
+	b := engine.NewBus()
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := &temporal.TestClock{}
+	clock.Set(now)
+	b.EnableEnvelopes(clock)
+	b.Use(engine.Journaled(j))
+
+	var dispatched *engine.Envelope
+	b.AddListener(func(ctx context.Context, ev testEvent) {
+		dispatched, _ = engine.EnvelopeFromContext(ctx)
+	})
+	b.DispatchContext(engine.WithSource(context.Background(), "test"), testEvent{7})
+
+	rd, err := NewReader(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+	rec, err := rd.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if rec.Event.Type != "journal.testEvent" {
+		t.Errorf("rec.Event.Type = %q, want %q", rec.Event.Type, "journal.testEvent")
+	}
+	if rec.Event.Version != 2 {
+		t.Errorf("rec.Event.Version = %d, want 2", rec.Event.Version)
+	}
+	if dispatched == nil || rec.Envelope.ID != dispatched.ID {
+		t.Errorf("rec.Envelope.ID = %q, want the dispatched envelope's ID", rec.Envelope.ID)
+	}
+	if rec.Envelope.Source != "test" {
+		t.Errorf("rec.Envelope.Source = %q, want %q", rec.Envelope.Source, "test")
+	}
+	if !rec.Envelope.Time.Earliest().Equal(now) {
+		t.Errorf("rec.Envelope.Time.Earliest() = %v, want %v", rec.Envelope.Time.Earliest(), now)
+	}
+	if _, err := rd.Next(); err != io.EOF {
+		t.Errorf("Next() error = %v, want io.EOF", err)
+	}
+}
+
+func TestSegmentPath(t *testing.T) {
+	want := filepath.Join("dir", "00000000000000000042.journal")
+	if got := segmentPath("dir", 42); got != want {
+		t.Errorf("segmentPath() = %q, want %q", got, want)
+	}
+}
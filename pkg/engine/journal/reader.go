@@ -0,0 +1,171 @@
+package journal
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// Reader iterates over the records of a journal in offset order. It may be
+// used while the journal is being appended to: once it has returned io.EOF,
+// a later call to Next returns the records appended in the meantime.
+//
+// A Reader is not safe for concurrent use.
+type Reader struct {
+	dir  string
+	from uint64 // skip records before this offset
+
+	base     uint64 // base offset of the current segment
+	f        *os.File
+	r        *bufio.Reader
+	pos      int64 // position of the next record in f
+	complete bool  // set once a later segment exists, so f is complete
+}
+
+// NewReader returns a Reader over the journal in dir, starting at the record
+// with offset from.
+func NewReader(dir string, from uint64) (*Reader, error) {
+	bases, err := segments(dir)
+	if err != nil {
+		return nil, err
+	}
+	rd := &Reader{dir: dir, from: from}
+	if len(bases) == 0 {
+		return rd, nil
+	}
+
+	// start in the last segment beginning at or before from
+	base := bases[0]
+	for _, b := range bases {
+		if b > from {
+			break
+		}
+		base = b
+	}
+	if err := rd.open(base); err != nil {
+		return nil, err
+	}
+	return rd, nil
+}
+
+func (rd *Reader) open(base uint64) error {
+	f, err := os.Open(segmentPath(rd.dir, base))
+	if err != nil {
+		return err
+	}
+	if rd.f != nil {
+		rd.f.Close()
+	}
+	rd.base, rd.f, rd.r, rd.pos, rd.complete = base, f, bufio.NewReader(f), 0, false
+	return nil
+}
+
+// Next returns the next record. It returns io.EOF if there are no more
+// records at the moment.
+func (rd *Reader) Next() (Record, error) {
+	for {
+		if rd.f == nil {
+			// the journal was empty; it may have been written since
+			bases, err := segments(rd.dir)
+			if err != nil {
+				return Record{}, err
+			}
+			if len(bases) == 0 {
+				return Record{}, io.EOF
+			}
+			if err := rd.open(bases[0]); err != nil {
+				return Record{}, err
+			}
+		}
+
+		rec, n, err := readRecord(rd.r)
+		switch err {
+		case nil:
+			rd.pos += n
+			if rec.Offset < rd.from {
+				continue
+			}
+			return rec.toRecord(), nil
+		case io.EOF, io.ErrUnexpectedEOF:
+			// the end of the segment, or a record still being written; a
+			// completed segment can't end within a record
+			if err == io.ErrUnexpectedEOF && rd.complete {
+				return Record{}, ErrCorrupt
+			}
+			if err := rd.rewind(); err != nil {
+				return Record{}, err
+			}
+			next, err := rd.nextSegment()
+			if err != nil {
+				return Record{}, err
+			}
+			if !next {
+				return Record{}, io.EOF
+			}
+		default:
+			return Record{}, err
+		}
+	}
+}
+
+// nextSegment moves on to the segment following the current one, if there
+// is one and the current one has been read completely.
+func (rd *Reader) nextSegment() (bool, error) {
+	bases, err := segments(rd.dir)
+	if err != nil {
+		return false, err
+	}
+	for _, b := range bases {
+		if b <= rd.base {
+			continue
+		}
+		// The journal completes a segment before starting the next one,
+		// but records may have been appended to it since the last read.
+		rd.complete = true
+		if _, err := rd.r.Peek(1); err == nil {
+			return true, nil
+		}
+		return true, rd.open(b)
+	}
+	return false, nil
+}
+
+// rewind positions the reader at the start of the first unread record, so a
+// partially read record is read again in full.
+func (rd *Reader) rewind() error {
+	if _, err := rd.f.Seek(rd.pos, io.SeekStart); err != nil {
+		return err
+	}
+	rd.r.Reset(rd.f)
+	return nil
+}
+
+// Close releases the resources of the Reader.
+func (rd *Reader) Close() error {
+	if rd.f == nil {
+		return nil
+	}
+	err := rd.f.Close()
+	rd.f = nil
+	return err
+}
@@ -0,0 +1,120 @@
+package journal
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/bhojpur/events/pkg/engine"
+)
+
+// Range selects the records of a journal to replay. The zero Range selects
+// all of them.
+type Range struct {
+	// From is the offset of the first record.
+	From uint64
+	// To is the offset after the last record, or zero for no limit.
+	To uint64
+	// Since, if not zero, skips the records dispatched before it.
+	Since time.Time
+	// Until, if not zero, skips the records dispatched at or after it.
+	Until time.Time
+}
+
+// contains reports whether rec is within r.
+func (r Range) contains(rec Record) bool {
+	if rec.Offset < r.From || (r.To != 0 && rec.Offset >= r.To) {
+		return false
+	}
+	if r.Since.IsZero() && r.Until.IsZero() {
+		return true
+	}
+	// records without a time can't be placed in a time range
+	t := rec.Envelope.Time.Earliest()
+	if t.IsZero() {
+		return false
+	}
+	return (r.Since.IsZero() || !t.Before(r.Since)) && (r.Until.IsZero() || t.Before(r.Until))
+}
+
+// Replay dispatches the events recorded in the journal in dir within r on
+// bus, or on the default Bus if bus is nil, in the order they were recorded.
+// The events are decoded with codec, and dispatched with a context marked by
+// engine.WithReplay and carrying their recorded Envelope.
+//
+// Failures of listeners are handled as usual by the Bus and don't stop the
+// replay, but an event that can't be read or decoded does. Replay returns
+// the number of events dispatched.
+func Replay(ctx context.Context, dir string, codec engine.EventCodec, r Range, bus *engine.Bus) (int, error) {
+	if bus == nil {
+		bus = engine.DefaultBus()
+	}
+
+	rd, err := NewReader(dir, r.From)
+	if err != nil {
+		return 0, err
+	}
+	defer rd.Close()
+
+	n := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		rec, err := rd.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if r.To != 0 && rec.Offset >= r.To {
+			return n, nil
+		}
+		if !r.contains(rec) {
+			continue
+		}
+
+		ev, err := codec.Decode(rec.Event)
+		if err != nil {
+			return n, err
+		}
+		env := rec.Envelope
+		if env.ID == "" {
+			// recorded without an envelope
+			bus.DispatchContext(engine.WithReplay(ctx, nil), ev)
+		} else {
+			bus.DispatchContext(engine.WithReplay(ctx, &env), ev)
+		}
+		n++
+	}
+}
+
+// ReplayListener is like Replay, but dispatches the events to the single
+// listener fn only, which takes any of the forms accepted by
+// engine.Bus.AddListener.
+func ReplayListener(ctx context.Context, dir string, codec engine.EventCodec, r Range, fn interface{}, opts ...engine.ListenerOption) (int, error) {
+	bus := engine.NewBus()
+	bus.AddListener(fn, opts...)
+	return Replay(ctx, dir, codec, r, bus)
+}
@@ -0,0 +1,120 @@
+package journal
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bhojpur/events/pkg/engine"
+	"github.com/bhojpur/events/pkg/temporal"
+)
+
+// recordEvents dispatches the events 0 to n-1 on a Bus journaling to dir, one
+// minute apart from start.
+func recordEvents(t *testing.T, dir string, start time.Time, n int) {
+	t.Helper()
+	j := openTest(t, dir, 200)
+	defer j.Close()
+
+	clock := &temporal.TestClock{}
+	b := engine.NewBus()
+	b.EnableEnvelopes(clock)
+	b.Use(engine.Journaled(j))
+	for i := 0; i < n; i++ {
+		clock.Set(start.Add(time.Duration(i) * time.Minute))
+		b.Dispatch(testEvent{i})
+	}
+}
+
+func TestReplayRange(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2018, 1, 2, 3, 4, 0, 0, time.UTC)
+	recordEvents(t, dir, start, 10)
+
+	for _, tc := range []struct {
+		name     string
+		r        Range
+		from, to int
+	}{
+		{"all", Range{}, 0, 10},
+		{"offsets", Range{From: 3, To: 7}, 3, 7},
+		{"from offset", Range{From: 8}, 8, 10},
+		{"times", Range{Since: start.Add(2 * time.Minute), Until: start.Add(5 * time.Minute)}, 2, 5},
+		{"offsets and times", Range{From: 4, Since: start.Add(2 * time.Minute), Until: start.Add(6 * time.Minute)}, 4, 6},
+	} {
+		var got []int
+		b := engine.NewBus()
+		b.AddListener(func(ctx context.Context, ev testEvent) {
+			if !engine.IsReplay(ctx) {
+				t.Errorf("%s: event %d not marked as replayed", tc.name, ev.N)
+			}
+			got = append(got, ev.N)
+		})
+		n, err := Replay(context.Background(), dir, testCodec{}, tc.r, b)
+		if err != nil {
+			t.Errorf("%s: Replay: %v", tc.name, err)
+		}
+		if n != len(got) {
+			t.Errorf("%s: Replay() = %d, want %d", tc.name, n, len(got))
+		}
+		checkEvents(t, got, tc.from, tc.to)
+	}
+}
+
+func TestReplayListener(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2018, 1, 2, 3, 4, 0, 0, time.UTC)
+	recordEvents(t, dir, start, 5)
+
+	var got []int
+	var times []time.Time
+	n, err := ReplayListener(context.Background(), dir, testCodec{}, Range{}, func(ctx context.Context, ev testEvent) {
+		got = append(got, ev.N)
+		env, _ := engine.EnvelopeFromContext(ctx)
+		times = append(times, env.Time.Earliest())
+	})
+	if err != nil || n != 5 {
+		t.Errorf("ReplayListener() = %d, %v, want 5, nil", n, err)
+	}
+	checkEvents(t, got, 0, 5)
+	for i, tm := range times {
+		if want := start.Add(time.Duration(i) * time.Minute); !tm.Equal(want) {
+			t.Errorf("event %d has time %v, want the recorded %v", i, tm, want)
+		}
+	}
+}
+
+func TestReplayCancel(t *testing.T) {
+	dir := t.TempDir()
+	recordEvents(t, dir, time.Now(), 5)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n, err := ReplayListener(ctx, dir, testCodec{}, Range{}, func(ev testEvent) {
+		if ev.N == 1 {
+			cancel()
+		}
+	})
+	if err != context.Canceled || n != 2 {
+		t.Errorf("ReplayListener() = %d, %v, want 2, %v", n, err, context.Canceled)
+	}
+}
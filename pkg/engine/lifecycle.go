@@ -0,0 +1,131 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bhojpur/events/pkg/log"
+)
+
+// DefaultStopTimeout bounds the OnStop hooks of a Lifecycle if its
+// StopTimeout is not set.
+const DefaultStopTimeout = 30 * time.Second
+
+// Lifecycle starts and stops the components of a process. Components add
+// their startup to OnStart and their shutdown to OnStop, using hook names and
+// After to order them, and the main function calls Run:
+//
+//	var lc engine.Lifecycle
+//	lc.OnStart.AddContext(startServer, engine.HookName("server"))
+//	lc.OnStop.AddContext(stopServer, engine.HookName("server"))
+//	lc.OnStop.AddContext(closeDB, engine.After("server"))
+//	if err := lc.Run(context.Background()); err != nil {
+//		log.Errorf("%v", err)
+//	}
+//
+// The zero Lifecycle is ready to use.
+type Lifecycle struct {
+	// OnStart holds the functions starting the process.
+	OnStart Hooks
+	// OnStop holds the functions stopping the process gracefully.
+	OnStop Hooks
+	// StopTimeout bounds the time OnStop may take. It defaults to
+	// DefaultStopTimeout.
+	StopTimeout time.Duration
+
+	// notify and exit default to signal.Notify and os.Exit
+	notify func(c chan<- os.Signal, sig ...os.Signal)
+	exit   func(code int)
+}
+
+// Start fires OnStart with ctx.
+func (lc *Lifecycle) Start(ctx context.Context) error {
+	return lc.OnStart.FireContext(ctx)
+}
+
+// Stop fires OnStop, giving it StopTimeout to finish.
+func (lc *Lifecycle) Stop(ctx context.Context) error {
+	timeout := lc.StopTimeout
+	if timeout <= 0 {
+		timeout = DefaultStopTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return lc.OnStop.FireContext(ctx)
+}
+
+// Run starts the process and blocks until it receives SIGINT or SIGTERM, or
+// until ctx is done, then stops it. If starting fails, all of OnStop is still
+// fired, so its hooks must cope with components that never started, and the
+// error of OnStart is returned. A second signal while stopping exits the
+// process immediately with status 1.
+func (lc *Lifecycle) Run(ctx context.Context) error {
+	notify, exit := lc.notify, lc.exit
+	if notify == nil {
+		notify = signal.Notify
+	}
+	if exit == nil {
+		exit = os.Exit
+	}
+
+	signals := make(chan os.Signal, 2)
+	notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	// a second signal while stopping forces the exit
+	stopping := make(chan struct{})
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-stopping:
+		case <-stopped:
+			return
+		}
+		select {
+		case sig := <-signals:
+			log.Errorf("received %v while stopping, exiting", sig)
+			exit(1)
+		case <-stopped:
+		}
+	}()
+
+	if err := lc.Start(ctx); err != nil {
+		close(stopping)
+		if serr := lc.Stop(context.Background()); serr != nil {
+			log.Errorf("can't stop after failed start: %v", serr)
+		}
+		return err
+	}
+
+	select {
+	case sig := <-signals:
+		log.Infof("received %v, stopping", sig)
+	case <-ctx.Done():
+	}
+	close(stopping)
+	return lc.Stop(context.Background())
+}
@@ -0,0 +1,139 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testLifecycle returns a Lifecycle whose signals are sent on the returned
+// channel, and whose forced exits are reported on the other.
+func testLifecycle() (*Lifecycle, chan<- os.Signal, <-chan int) {
+	signals := make(chan chan<- os.Signal, 1)
+	exits := make(chan int, 1)
+	lc := &Lifecycle{
+		notify: func(c chan<- os.Signal, sig ...os.Signal) { signals <- c },
+		exit:   func(code int) { exits <- code },
+	}
+	send := make(chan os.Signal)
+	go func() {
+		c := <-signals
+		for sig := range send {
+			c <- sig
+		}
+	}()
+	return lc, send, exits
+}
+
+func TestLifecycleSignal(t *testing.T) {
+	lc, send, _ := testLifecycle()
+	defer close(send)
+
+	var started, stopped bool
+	lc.OnStart.Add(func() { started = true })
+	lc.OnStop.Add(func() { stopped = true })
+
+	done := make(chan error)
+	go func() { done <- lc.Run(context.Background()) }()
+	send <- syscall.SIGTERM
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run() didn't return after SIGTERM")
+	}
+	if !started || !stopped {
+		t.Errorf("started = %v, stopped = %v, want both", started, stopped)
+	}
+}
+
+func TestLifecycleContext(t *testing.T) {
+	lc, send, _ := testLifecycle()
+	defer close(send)
+	stopped := false
+	lc.OnStop.Add(func() { stopped = true })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := lc.Run(ctx); err != nil || !stopped {
+		t.Errorf("Run() = %v, stopped = %v", err, stopped)
+	}
+}
+
+func TestLifecycleStopTimeout(t *testing.T) {
+	lc, send, _ := testLifecycle()
+	defer close(send)
+	lc.StopTimeout = 20 * time.Millisecond
+	lc.OnStop.AddContext(hungHook)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := lc.Run(ctx)
+	var failed HookErrors
+	if !errors.As(err, &failed) || !errors.Is(failed[0], context.DeadlineExceeded) {
+		t.Errorf("Run() = %v, want a timed out hook", err)
+	}
+}
+
+func TestLifecycleStartFailure(t *testing.T) {
+	lc, send, _ := testLifecycle()
+	defer close(send)
+	stopped := false
+	lc.OnStart.AddContext(failingHook)
+	lc.OnStop.Add(func() { stopped = true })
+
+	var failed HookErrors
+	if err := lc.Run(context.Background()); !errors.As(err, &failed) {
+		t.Errorf("Run() = %v, want the start failure", err)
+	}
+	if !stopped {
+		t.Errorf("OnStop not fired after failed start")
+	}
+}
+
+func TestLifecycleForcedExit(t *testing.T) {
+	lc, send, exits := testLifecycle()
+	defer close(send)
+	release := make(chan struct{})
+	defer close(release)
+	lc.OnStop.Add(func() { <-release })
+
+	go lc.Run(context.Background())
+	send <- syscall.SIGINT
+	send <- syscall.SIGINT
+
+	select {
+	case code := <-exits:
+		if code != 1 {
+			t.Errorf("exited with %d, want 1", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("second signal didn't force an exit")
+	}
+}
@@ -0,0 +1,160 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"reflect"
+)
+
+// MatchMode selects additional event types a listener receives besides its
+// declared type and the types implementing it. Modes can be combined with |.
+type MatchMode int
+
+const (
+	// MatchDeref lets a listener for a type T also receive events of type
+	// *T. The listener is called with a copy of the value the event points
+	// to; nil pointers are skipped.
+	MatchDeref MatchMode = 1 << iota
+
+	// MatchEmbedded lets a listener for a type B (or *B) receive events
+	// whose struct type embeds B or *B, directly or through other embedded
+	// structs, as long as all of them are exported. The listener is called
+	// with the embedded value. A listener for *B only receives events
+	// embedding B by value if they are dispatched by pointer, so that the
+	// embedded field is addressable. Events for which the embedded value
+	// can't be reached through nil pointers are skipped.
+	MatchEmbedded
+)
+
+// Match sets the match mode of a listener. It has no effect on listeners for
+// interface types.
+//
+// For example, a listener for all events embedding status.StatusUpdater that
+// are dispatched by pointer:
+//
+//	engine.AddListener(func(su *status.StatusUpdater) {
+//	  ...
+//	}, engine.Match(engine.MatchEmbedded))
+func Match(mode MatchMode) ListenerOption {
+	return func(l *listener) {
+		l.match = mode
+	}
+}
+
+// adapter returns a function converting events of type evType into the
+// argument type of l according to its match mode, or nil if l does not match
+// evType that way.
+func (l *listener) adapter(evType reflect.Type) func(interface{}) (interface{}, bool) {
+	if evType == l.evType {
+		// exact matches are handled by Bus.resolve
+		return nil
+	}
+
+	if l.match&MatchDeref != 0 && evType.Kind() == reflect.Ptr && evType.Elem() == l.evType {
+		return func(ev interface{}) (interface{}, bool) {
+			v := reflect.ValueOf(ev)
+			if v.IsNil() {
+				return nil, false
+			}
+			return v.Elem().Interface(), true
+		}
+	}
+
+	if l.match&MatchEmbedded != 0 {
+		return l.embeddedAdapter(evType)
+	}
+	return nil
+}
+
+// embeddedAdapter returns a function extracting the field of type l.evType
+// embedded in events of type evType, or nil if there is no such field.
+func (l *listener) embeddedAdapter(evType reflect.Type) func(interface{}) (interface{}, bool) {
+	structType := evType
+	byPointer := evType.Kind() == reflect.Ptr
+	if byPointer {
+		structType = evType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return nil
+	}
+
+	// the embedded field of type B or *B, where l.evType is B or *B
+	base := l.evType
+	if base.Kind() == reflect.Ptr {
+		base = base.Elem()
+	}
+	field, ok := structType.FieldByName(base.Name())
+	if !ok || !field.Anonymous || field.Type != base && field.Type != reflect.PtrTo(base) {
+		return nil
+	}
+	if !exportedPath(structType, field.Index) {
+		// reflection can't hand out values of unexported fields
+		return nil
+	}
+
+	wantPointer := l.evType.Kind() == reflect.Ptr
+	fieldIsPointer := field.Type.Kind() == reflect.Ptr
+	if wantPointer && !fieldIsPointer && !byPointer {
+		// the embedded field is not addressable
+		return nil
+	}
+
+	return func(ev interface{}) (interface{}, bool) {
+		v := reflect.ValueOf(ev)
+		if byPointer {
+			if v.IsNil() {
+				return nil, false
+			}
+			v = v.Elem()
+		}
+		f, err := v.FieldByIndexErr(field.Index)
+		if err != nil {
+			// nil embedded pointer on the way to the field
+			return nil, false
+		}
+
+		switch {
+		case fieldIsPointer && f.IsNil():
+			return nil, false
+		case fieldIsPointer && !wantPointer:
+			f = f.Elem()
+		case !fieldIsPointer && wantPointer:
+			f = f.Addr()
+		}
+		return f.Interface(), true
+	}
+}
+
+// exportedPath reports whether all fields on the path given by index are
+// exported.
+func exportedPath(t reflect.Type, index []int) bool {
+	for _, i := range index {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		f := t.Field(i)
+		if !f.IsExported() {
+			return false
+		}
+		t = f.Type
+	}
+	return true
+}
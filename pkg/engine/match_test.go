@@ -0,0 +1,164 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// The embedding tests need exported types, since reflection can't read
+// unexported embedded fields.
+
+type BaseEvent struct {
+	ID int
+}
+
+type EmbeddingEvent struct {
+	BaseEvent
+	Name string
+}
+
+type PointerEmbeddingEvent struct {
+	*BaseEvent
+}
+
+type NestedEmbeddingEvent struct {
+	EmbeddingEvent
+}
+
+func TestMatchDeref(t *testing.T) {
+	b := NewBus()
+
+	var got []testEvent2
+	b.AddListener(func(ev testEvent2) { got = append(got, ev) }, Match(MatchDeref))
+	b.Dispatch(&testEvent2{triggered: true})
+	b.Dispatch(testEvent2{})
+	b.Dispatch((*testEvent2)(nil))
+
+	if want := []testEvent2{{triggered: true}, {}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listener got %v, want %v", got, want)
+	}
+}
+
+func TestMatchNilEvent(t *testing.T) {
+	b := NewBus()
+
+	var typed, wildcard int
+	b.AddListener(func(ev testEvent2) { typed++ }, Match(MatchDeref))
+	b.AddListener(func(ev fmt.Stringer) { typed++ })
+	SubscribeAll(b, func(ctx context.Context, ev interface{}, info EventInfo) error {
+		wildcard++
+		return nil
+	})
+	b.Dispatch(nil)
+
+	if typed != 0 || wildcard != 1 {
+		t.Errorf("nil event reached %d typed and %d wildcard listeners, want 0 and 1", typed, wildcard)
+	}
+}
+
+func TestMatchDerefTyped(t *testing.T) {
+	b := NewBus()
+
+	triggered := false
+	Subscribe(b, func(testEvent2) { triggered = true }, Match(MatchDeref))
+	Publish(b, &testEvent2{})
+
+	if !triggered {
+		t.Errorf("typed listener with MatchDeref failed to trigger for pointer")
+	}
+}
+
+func TestMatchEmbeddedValue(t *testing.T) {
+	b := NewBus()
+
+	var got []int
+	b.AddListener(func(ev BaseEvent) { got = append(got, ev.ID) }, Match(MatchEmbedded))
+	b.Dispatch(EmbeddingEvent{BaseEvent: BaseEvent{ID: 1}})
+	b.Dispatch(&EmbeddingEvent{BaseEvent: BaseEvent{ID: 2}})
+	b.Dispatch(PointerEmbeddingEvent{&BaseEvent{ID: 3}})
+	b.Dispatch(PointerEmbeddingEvent{})
+	b.Dispatch(NestedEmbeddingEvent{EmbeddingEvent{BaseEvent: BaseEvent{ID: 4}}})
+	b.Dispatch(BaseEvent{ID: 5})
+
+	if want := []int{1, 2, 3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listener got IDs %v, want %v", got, want)
+	}
+}
+
+func TestMatchEmbeddedPointer(t *testing.T) {
+	b := NewBus()
+
+	b.AddListener(func(ev *BaseEvent) { ev.ID++ }, Match(MatchEmbedded))
+
+	ev := &EmbeddingEvent{}
+	b.Dispatch(ev)
+	if ev.ID != 1 {
+		t.Errorf("listener did not receive the embedded field of a pointer event")
+	}
+
+	pev := PointerEmbeddingEvent{&BaseEvent{}}
+	b.Dispatch(pev)
+	if pev.ID != 1 {
+		t.Errorf("listener did not receive the embedded pointer of an event")
+	}
+
+	// not addressable, must not trigger
+	b.Dispatch(EmbeddingEvent{})
+}
+
+type unexportedEmbeddingEvent struct {
+	testEvent2
+}
+
+func TestMatchEmbeddedUnexported(t *testing.T) {
+	b := NewBus()
+
+	b.AddListener(func(testEvent2) { t.Errorf("listener triggered for unexported embedded field") }, Match(MatchEmbedded))
+	b.Dispatch(unexportedEmbeddingEvent{})
+}
+
+func TestMatchDefault(t *testing.T) {
+	b := NewBus()
+
+	b.AddListener(func(BaseEvent) { t.Errorf("listener without match mode triggered on embedding type") })
+	b.AddListener(func(testEvent2) { t.Errorf("listener without match mode triggered on pointer type") })
+	b.Dispatch(&EmbeddingEvent{})
+	b.Dispatch(&testEvent2{})
+}
+
+func TestMatchOrderAndCancel(t *testing.T) {
+	b := NewBus()
+
+	var order []string
+	b.AddListener(func(EmbeddingEvent) { order = append(order, "concrete") })
+	sub := b.AddListener(func(BaseEvent) { order = append(order, "embedded") }, Match(MatchEmbedded), Priority(1))
+	b.Dispatch(EmbeddingEvent{})
+	sub.Cancel()
+	b.Dispatch(EmbeddingEvent{})
+
+	if want := []string{"embedded", "concrete", "concrete"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("listeners called in order %v, want %v", order, want)
+	}
+}
@@ -0,0 +1,332 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the buckets of latency histograms.
+var latencyBuckets = []time.Duration{
+	time.Microsecond, 5 * time.Microsecond, 10 * time.Microsecond, 50 * time.Microsecond,
+	100 * time.Microsecond, 500 * time.Microsecond, time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 5 * time.Second,
+}
+
+// histogram counts latencies in latencyBuckets. Its fields are accessed
+// atomically.
+type histogram struct {
+	counts []uint64 // per bucket, plus one for larger latencies
+	sum    uint64   // in nanoseconds
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return d <= latencyBuckets[i] })
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.sum, uint64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+	s := Histogram{
+		Bounds: latencyBuckets,
+		Counts: make([]uint64, len(h.counts)),
+		Sum:    time.Duration(atomic.LoadUint64(&h.sum)),
+	}
+	// the total is derived from the buckets, so that it is consistent with
+	// them even while latencies are observed
+	for i := range h.counts {
+		s.Counts[i] = atomic.LoadUint64(&h.counts[i])
+		s.Count += s.Counts[i]
+	}
+	return s
+}
+
+// Histogram is a snapshot of a latency distribution.
+type Histogram struct {
+	// Bounds are the upper bounds of the buckets, in increasing order. They
+	// are shared between histograms and must not be modified.
+	Bounds []time.Duration
+	// Counts holds the number of latencies in each bucket, not cumulatively,
+	// and a last element for latencies above all Bounds.
+	Counts []uint64
+	// Count is the total number of latencies.
+	Count uint64
+	// Sum is the total of all latencies.
+	Sum time.Duration
+}
+
+// listenerStats are the metrics of a listener. Its counters are accessed
+// atomically.
+type listenerStats struct {
+	calls    uint64
+	failures uint64
+	latency  *histogram
+}
+
+// eventStats are the metrics of an event type.
+type eventStats struct {
+	dispatched uint64 // accessed atomically
+	latency    *histogram
+}
+
+// EnableMetrics starts collecting metrics on the default Bus, see
+// Bus.EnableMetrics.
+func EnableMetrics() {
+	defaultBus.EnableMetrics()
+}
+
+// EnableMetrics starts counting the events dispatched on b and the calls of
+// each listener, and measuring how long they take. Metrics cost two clock
+// readings per listener call and per dispatch, so they are disabled by
+// default. Read them with EventMetrics and Listeners, or export them with
+// WritePrometheus.
+func (b *Bus) EnableMetrics() {
+	atomic.StoreInt32(&b.metrics, 1)
+}
+
+func (b *Bus) metricsEnabled() bool {
+	return atomic.LoadInt32(&b.metrics) != 0
+}
+
+// typeStats returns the metrics of evType.
+func (b *Bus) typeStats(evType reflect.Type) *eventStats {
+	if s, ok := b.eventStats.Load(evType); ok {
+		return s.(*eventStats)
+	}
+	s, _ := b.eventStats.LoadOrStore(evType, &eventStats{latency: newHistogram()})
+	return s.(*eventStats)
+}
+
+// observeDispatch records a dispatch of an event of type evType that started
+// at start.
+func (b *Bus) observeDispatch(evType reflect.Type, start time.Time) {
+	s := b.typeStats(evType)
+	atomic.AddUint64(&s.dispatched, 1)
+	s.latency.observe(time.Since(start))
+}
+
+// observeCall records a call of l that started at start.
+func (l *listener) observeCall(start time.Time, failed bool) {
+	atomic.AddUint64(&l.stats.calls, 1)
+	if failed {
+		atomic.AddUint64(&l.stats.failures, 1)
+	}
+	l.stats.latency.observe(time.Since(start))
+}
+
+// EventMetrics are the metrics of an event type.
+type EventMetrics struct {
+	// Type is the TypeName of the event type.
+	Type string
+	// Dispatched is the number of events of the type dispatched.
+	Dispatched uint64
+	// Latency is the distribution of the time taken by Dispatch, including
+	// interceptors and synchronous listeners.
+	Latency Histogram
+}
+
+// EventMetrics returns the metrics of every event type dispatched since
+// metrics were enabled, ordered by type name.
+func (b *Bus) EventMetrics() []EventMetrics {
+	var ms []EventMetrics
+	b.eventStats.Range(func(k, v interface{}) bool {
+		s := v.(*eventStats)
+		t, _ := k.(reflect.Type) // nil for Dispatch(nil)
+		ms = append(ms, EventMetrics{
+			Type:       TypeName(t),
+			Dispatched: atomic.LoadUint64(&s.dispatched),
+			Latency:    s.latency.snapshot(),
+		})
+		return true
+	})
+	sort.Slice(ms, func(i, k int) bool { return ms[i].Type < ms[k].Type })
+	return ms
+}
+
+// ListenerInfo describes a registered listener.
+type ListenerInfo struct {
+	// ID identifies the listener on its Bus; it is its registration order.
+	ID uint64
+	// Name is the name of the listener function, as reported by
+	// runtime.FuncForPC.
+	Name string
+	// Type is the TypeName of the type the listener is registered for, or
+	// the empty string for a wildcard listener.
+	Type string
+	// Interface is set if Type is an interface type.
+	Interface bool
+	// Priority is the priority of the listener, see Priority.
+	Priority int
+	// Calls is the number of times the listener was called, and Failures the
+	// number of those that returned an error or panicked. Both are only
+	// counted while metrics are enabled.
+	Calls, Failures uint64
+	// Dropped is the number of events dropped for the listener, see
+	// Subscription.Dropped.
+	Dropped uint64
+	// Latency is the distribution of the durations of the listener's calls,
+	// while metrics are enabled.
+	Latency Histogram
+}
+
+// Listeners returns a description of every listener registered on b, in
+// registration order.
+func (b *Bus) Listeners() []ListenerInfo {
+	b.mu.RLock()
+	ls := append([]*listener(nil), b.wildcards...)
+	for _, tls := range b.listeners {
+		ls = append(ls, tls...)
+	}
+	b.mu.RUnlock()
+
+	sort.Slice(ls, func(i, k int) bool { return ls[i].seq < ls[k].seq })
+	infos := make([]ListenerInfo, len(ls))
+	for i, l := range ls {
+		infos[i] = ListenerInfo{
+			ID:       l.seq,
+			Name:     l.name,
+			Priority: l.priority,
+			Calls:    atomic.LoadUint64(&l.stats.calls),
+			Failures: atomic.LoadUint64(&l.stats.failures),
+			Latency:  l.stats.latency.snapshot(),
+		}
+		if l.evType != nil {
+			infos[i].Type = TypeName(l.evType)
+			infos[i].Interface = l.evType.Kind() == reflect.Interface
+		}
+		if l.queue != nil {
+			infos[i].Dropped = atomic.LoadUint64(&l.queue.dropped)
+		}
+	}
+	return infos
+}
+
+// Types returns the TypeNames of the types that listeners are registered
+// for, including interfaces, in alphabetical order.
+func (b *Bus) Types() []string {
+	b.mu.RLock()
+	names := make([]string, 0, len(b.listeners))
+	for t := range b.listeners {
+		names = append(names, TypeName(t))
+	}
+	b.mu.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// WritePrometheus writes the metrics of b to w in the Prometheus text
+// exposition format, for example to serve them over HTTP:
+//
+//	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+//		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+//		engine.DefaultBus().WritePrometheus(w)
+//	})
+//
+// Metric names are prefixed with "events_".
+func (b *Bus) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	events, listeners := b.EventMetrics(), b.Listeners()
+
+	header(bw, "events_dispatched_total", "counter", "Number of events dispatched, by event type.")
+	for _, m := range events {
+		fmt.Fprintf(bw, "events_dispatched_total{type=%s} %d\n", quote(m.Type), m.Dispatched)
+	}
+	header(bw, "events_dispatch_duration_seconds", "histogram", "Time taken to dispatch events, by event type.")
+	for _, m := range events {
+		writeHistogram(bw, "events_dispatch_duration_seconds", "type="+quote(m.Type), m.Latency)
+	}
+
+	counts := make(map[string]int)
+	for _, l := range listeners {
+		counts[l.Type]++
+	}
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	header(bw, "events_listeners", "gauge", "Number of registered listeners, by event type; wildcard listeners have an empty type.")
+	for _, t := range types {
+		fmt.Fprintf(bw, "events_listeners{type=%s} %d\n", quote(t), counts[t])
+	}
+
+	labels := func(l ListenerInfo) string {
+		return fmt.Sprintf("id=\"%d\",listener=%s,type=%s", l.ID, quote(l.Name), quote(l.Type))
+	}
+	header(bw, "events_listener_calls_total", "counter", "Number of listener calls.")
+	for _, l := range listeners {
+		fmt.Fprintf(bw, "events_listener_calls_total{%s} %d\n", labels(l), l.Calls)
+	}
+	header(bw, "events_listener_failures_total", "counter", "Number of listener calls that returned an error or panicked.")
+	for _, l := range listeners {
+		fmt.Fprintf(bw, "events_listener_failures_total{%s} %d\n", labels(l), l.Failures)
+	}
+	header(bw, "events_listener_dropped_total", "counter", "Number of events dropped because a listener's queue was full.")
+	for _, l := range listeners {
+		fmt.Fprintf(bw, "events_listener_dropped_total{%s} %d\n", labels(l), l.Dropped)
+	}
+	header(bw, "events_listener_duration_seconds", "histogram", "Duration of listener calls.")
+	for _, l := range listeners {
+		writeHistogram(bw, "events_listener_duration_seconds", labels(l), l.Latency)
+	}
+
+	return bw.Flush()
+}
+
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeHistogram(w io.Writer, name, labels string, h Histogram) {
+	var cumulative uint64
+	for i, bound := range h.Bounds {
+		cumulative += h.Counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, seconds(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.Count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, seconds(h.Sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.Count)
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+// labelEscaper escapes label values as required by the exposition format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quote(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
@@ -0,0 +1,178 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+type metricsIface interface {
+	metrics()
+}
+
+type metricsEvent struct{}
+
+func (metricsEvent) metrics() {}
+
+func metricsListener(ev metricsEvent) {}
+
+func TestListeners(t *testing.T) {
+	b := NewBus()
+	b.AddListener(metricsListener, Priority(3))
+	Subscribe(b, func(metricsIface) {})
+	SubscribeAll(b, func(context.Context, interface{}, EventInfo) error { return nil })
+
+	infos := b.Listeners()
+	if len(infos) != 3 {
+		t.Fatalf("Listeners() returned %d listeners, want 3", len(infos))
+	}
+	if infos[0].Name != "github.com/bhojpur/events/pkg/engine.metricsListener" {
+		t.Errorf("infos[0].Name = %q", infos[0].Name)
+	}
+	if infos[0].Type != "github.com/bhojpur/events/pkg/engine.metricsEvent" || infos[0].Interface || infos[0].Priority != 3 {
+		t.Errorf("infos[0] = %+v", infos[0])
+	}
+	if infos[1].Type != "github.com/bhojpur/events/pkg/engine.metricsIface" || !infos[1].Interface {
+		t.Errorf("infos[1] = %+v", infos[1])
+	}
+	if infos[2].Type != "" {
+		t.Errorf("infos[2].Type = %q, want a wildcard listener", infos[2].Type)
+	}
+
+	types := b.Types()
+	want := []string{"github.com/bhojpur/events/pkg/engine.metricsEvent", "github.com/bhojpur/events/pkg/engine.metricsIface"}
+	if fmt.Sprint(types) != fmt.Sprint(want) {
+		t.Errorf("Types() = %v, want %v", types, want)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	b := NewBus()
+	b.SetErrorHandler(func(*ListenerError) {})
+	b.AddListener(func(ev metricsEvent) {})
+	b.AddListener(func(ev metricsEvent) error {
+		time.Sleep(2 * time.Millisecond)
+		return errors.New("failed")
+	})
+
+	b.Dispatch(metricsEvent{})
+	if ls := b.Listeners(); ls[0].Calls != 0 || len(b.EventMetrics()) != 0 {
+		t.Errorf("metrics collected before EnableMetrics")
+	}
+
+	b.EnableMetrics()
+	b.Dispatch(metricsEvent{})
+	b.Dispatch(metricsEvent{})
+
+	// the failures are dispatched as ListenerFailed events
+	events := b.EventMetrics()
+	if len(events) != 2 || events[0].Type != "github.com/bhojpur/events/pkg/engine.ListenerFailed" {
+		t.Fatalf("EventMetrics() = %+v", events)
+	}
+	if m := events[1]; m.Dispatched != 2 || m.Latency.Count != 2 {
+		t.Errorf("EventMetrics()[1] = %+v, want 2 dispatches", m)
+	}
+	if events[1].Latency.Sum < 4*time.Millisecond {
+		t.Errorf("dispatch latency sum = %v, want at least 4ms", events[1].Latency.Sum)
+	}
+
+	ls := b.Listeners()
+	if ls[0].Calls != 2 || ls[0].Failures != 0 {
+		t.Errorf("listener 0 has %d calls and %d failures, want 2 and 0", ls[0].Calls, ls[0].Failures)
+	}
+	if ls[1].Calls != 2 || ls[1].Failures != 2 {
+		t.Errorf("listener 1 has %d calls and %d failures, want 2 and 2", ls[1].Calls, ls[1].Failures)
+	}
+	h := ls[1].Latency
+	var n uint64
+	for i, c := range h.Counts {
+		if c > 0 && i < len(h.Bounds) && h.Bounds[i] < 2*time.Millisecond {
+			t.Errorf("%d calls of a 2ms listener in bucket up to %v", c, h.Bounds[i])
+		}
+		n += c
+	}
+	if n != 2 {
+		t.Errorf("histogram holds %d calls, want 2", n)
+	}
+}
+
+func TestMetricsNilEvent(t *testing.T) {
+	b := NewBus()
+	b.EnableMetrics()
+	b.Dispatch(nil)
+
+	events := b.EventMetrics()
+	if len(events) != 1 || events[0].Type != "nil" || events[0].Dispatched != 1 {
+		t.Errorf("EventMetrics() = %+v, want 1 dispatch of nil", events)
+	}
+	if err := b.WritePrometheus(io.Discard); err != nil {
+		t.Errorf("WritePrometheus: %v", err)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	b := NewBus()
+	b.EnableMetrics()
+	b.AddListener(metricsListener)
+	b.Dispatch(metricsEvent{})
+
+	var sb strings.Builder
+	if err := b.WritePrometheus(&sb); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	out := sb.String()
+	for _, want := range []string{
+		"# TYPE events_dispatched_total counter\n",
+		`events_dispatched_total{type="github.com/bhojpur/events/pkg/engine.metricsEvent"} 1` + "\n",
+		`events_dispatch_duration_seconds_bucket{type="github.com/bhojpur/events/pkg/engine.metricsEvent",le="+Inf"} 1` + "\n",
+		`events_dispatch_duration_seconds_count{type="github.com/bhojpur/events/pkg/engine.metricsEvent"} 1` + "\n",
+		`events_listeners{type="github.com/bhojpur/events/pkg/engine.metricsEvent"} 1` + "\n",
+		`events_listener_calls_total{id="1",listener="github.com/bhojpur/events/pkg/engine.metricsListener",type="github.com/bhojpur/events/pkg/engine.metricsEvent"} 1` + "\n",
+		`events_listener_duration_seconds_bucket{id="1",listener="github.com/bhojpur/events/pkg/engine.metricsListener",type="github.com/bhojpur/events/pkg/engine.metricsEvent",le="1e-06"} `,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestQuoteLabel(t *testing.T) {
+	if got, want := quote("a\"b\\c\nd"), `"a\"b\\c\nd"`; got != want {
+		t.Errorf("quote() = %s, want %s", got, want)
+	}
+}
+
+func BenchmarkDispatchMetrics(b *testing.B) {
+	bus := NewBus()
+	bus.EnableMetrics()
+	bus.AddListener(metricsListener)
+	ev := metricsEvent{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bus.Dispatch(ev)
+	}
+}
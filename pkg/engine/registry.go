@@ -0,0 +1,336 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrUnknownType is returned by Registry when an event type or type name has
+// not been registered.
+var ErrUnknownType = errors.New("engine: unknown event type")
+
+// Codec serializes the values of registered event types, see Registry.
+type Codec interface {
+	// Marshal serializes v.
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal deserializes data into the value v points to.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec serializes events with package encoding/json.
+	JSONCodec Codec = jsonCodec{}
+	// GobCodec serializes events with package encoding/gob.
+	GobCodec Codec = gobCodec{}
+	// ProtoCodec serializes events in the protocol buffers wire format. The
+	// event types must be generated protocol buffer messages, or pointers to
+	// them.
+	ProtoCodec Codec = protoCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type protoCodec struct{}
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		// messages implement proto.Message with pointer receivers
+		p := reflect.New(reflect.TypeOf(v))
+		p.Elem().Set(reflect.ValueOf(v))
+		if m, ok = p.Interface().(proto.Message); !ok {
+			return nil, fmt.Errorf("engine: %T is not a protocol buffer message", v)
+		}
+	}
+	return proto.Marshal(m)
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("engine: %T is not a protocol buffer message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// registration is an event type registered with a Registry.
+type registration struct {
+	name    string
+	t       reflect.Type
+	codec   Codec
+	version int
+}
+
+// TypeOption is an option for Registry.Register.
+type TypeOption func(*registration)
+
+// Named sets the name identifying the event type in encoded events, which
+// defaults to its TypeName. Names should stay the same when Go types are
+// renamed or moved, so that events encoded before can still be decoded.
+func Named(name string) TypeOption {
+	return func(reg *registration) {
+		reg.name = name
+	}
+}
+
+// Using sets the Codec for the event type, instead of the default Codec of
+// the Registry.
+func Using(codec Codec) TypeOption {
+	return func(reg *registration) {
+		reg.codec = codec
+	}
+}
+
+// Version sets the current schema version of the event type, which
+// defaults to zero. Events are encoded with the current version, and events
+// encoded with an earlier one are upgraded by the upcasters registered with
+// Registry.Upcast when decoded.
+func Version(version int) TypeOption {
+	return func(reg *registration) {
+		reg.version = version
+	}
+}
+
+// Upcaster upgrades the serialized data of an event from one schema version
+// to the next. It works on the encoding of the Codec of the event type.
+type Upcaster func(data []byte) ([]byte, error)
+
+// JSONUpcaster returns an Upcaster for events serialized with JSONCodec,
+// which modifies the decoded JSON object in place. For example, to rename
+// field "Name" to "Title":
+//
+//	r.Upcast("orders.placed", 1, engine.JSONUpcaster(func(obj map[string]interface{}) error {
+//		obj["Title"] = obj["Name"]
+//		delete(obj, "Name")
+//		return nil
+//	}))
+func JSONUpcaster(fn func(obj map[string]interface{}) error) Upcaster {
+	return func(data []byte) ([]byte, error) {
+		var obj map[string]interface{}
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil, err
+		}
+		if err := fn(obj); err != nil {
+			return nil, err
+		}
+		return json.Marshal(obj)
+	}
+}
+
+// Registry maps stable names to event types, so that events can be encoded
+// for storage or transmission to other processes, and decoded back to values
+// of their original type to be dispatched again. It implements EventCodec and
+// is safe for concurrent use.
+//
+// Event types are typically registered when the program starts:
+//
+//	var Events = engine.NewRegistry(engine.JSONCodec)
+//
+//	func init() {
+//		Events.Register(OrderPlaced{}, engine.Named("orders.placed"))
+//		Events.Register(&pb.Shipment{}, engine.Using(engine.ProtoCodec))
+//	}
+type Registry struct {
+	codec Codec // default codec
+
+	mu        sync.RWMutex // protects the maps below
+	byName    map[string]*registration
+	byType    map[reflect.Type]*registration
+	upcasters map[string]map[int]Upcaster // by type name and source version
+}
+
+var _ EventCodec = (*Registry)(nil) // compile-time interface check
+
+// NewRegistry returns an empty Registry, which serializes events with codec
+// unless they are registered with another Codec.
+func NewRegistry(codec Codec) *Registry {
+	return &Registry{
+		codec:     codec,
+		byName:    make(map[string]*registration),
+		byType:    make(map[reflect.Type]*registration),
+		upcasters: make(map[string]map[int]Upcaster),
+	}
+}
+
+// Register adds the dynamic type of ev, which is only used for its type, to
+// the registry. A pointer type is distinct from the type it points to.
+//
+// Register panics if ev is nil, or if the type or its name is already
+// registered.
+func (r *Registry) Register(ev interface{}, opts ...TypeOption) {
+	t := reflect.TypeOf(ev)
+	if t == nil {
+		panic("engine: can't register nil event type")
+	}
+	reg := &registration{name: TypeName(t), t: t, codec: r.codec}
+	for _, opt := range opts {
+		opt(reg)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if other, ok := r.byType[t]; ok {
+		panic(fmt.Sprintf("engine: event type %v already registered as %q", t, other.name))
+	}
+	if other, ok := r.byName[reg.name]; ok {
+		panic(fmt.Sprintf("engine: event type name %q already registered for %v", reg.name, other.t))
+	}
+	r.byName[reg.name] = reg
+	r.byType[t] = reg
+}
+
+// Upcast registers fn to upgrade events of the type registered with name
+// from schema version from to version from+1. Decode applies as many
+// upcasters in sequence as needed to bring an event to the current version
+// of its type. The type doesn't need to be registered yet.
+//
+// Upcast panics if an upcaster from that version is already registered.
+func (r *Registry) Upcast(name string, from int, fn Upcaster) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ups := r.upcasters[name]
+	if ups == nil {
+		ups = make(map[int]Upcaster)
+		r.upcasters[name] = ups
+	}
+	if _, ok := ups[from]; ok {
+		panic(fmt.Sprintf("engine: upcaster for %q from version %d already registered", name, from))
+	}
+	ups[from] = fn
+}
+
+// Name returns the name event type t was registered with.
+func (r *Registry) Name(t reflect.Type) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	reg, ok := r.byType[t]
+	if !ok {
+		return "", false
+	}
+	return reg.name, true
+}
+
+// Type returns the event type registered with name.
+func (r *Registry) Type(name string) (reflect.Type, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	reg, ok := r.byName[name]
+	if !ok {
+		return nil, false
+	}
+	return reg.t, true
+}
+
+// Encode serializes ev with the Codec of its type. It returns an error
+// wrapping ErrUnknownType if the type is not registered.
+func (r *Registry) Encode(ev interface{}) (EncodedEvent, error) {
+	t := reflect.TypeOf(ev)
+	r.mu.RLock()
+	reg, ok := r.byType[t]
+	r.mu.RUnlock()
+	if !ok {
+		return EncodedEvent{}, fmt.Errorf("%w: %v", ErrUnknownType, t)
+	}
+
+	data, err := reg.codec.Marshal(ev)
+	if err != nil {
+		return EncodedEvent{}, fmt.Errorf("engine: can't encode %s event: %w", reg.name, err)
+	}
+	return EncodedEvent{Type: reg.name, Version: reg.version, Data: data}, nil
+}
+
+// Decode restores an event encoded by Encode to a value of its registered
+// type, upgrading it to the current schema version first if needed. It
+// returns an error wrapping ErrUnknownType if enc.Type is not registered.
+func (r *Registry) Decode(enc EncodedEvent) (interface{}, error) {
+	r.mu.RLock()
+	reg, ok := r.byName[enc.Type]
+	// copy the upcasters needed, as Upcast may add to the map concurrently
+	var chain []Upcaster
+	for v := enc.Version; ok && v < reg.version; v++ {
+		up, found := r.upcasters[enc.Type][v]
+		if !found {
+			break
+		}
+		chain = append(chain, up)
+	}
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownType, enc.Type)
+	}
+
+	if enc.Version > reg.version {
+		return nil, fmt.Errorf("engine: can't decode %s event of version %d, newer than %d", enc.Type, enc.Version, reg.version)
+	}
+	if v := enc.Version + len(chain); v < reg.version {
+		return nil, fmt.Errorf("engine: can't upgrade %s event from version %d: no upcaster", enc.Type, v)
+	}
+	for i, up := range chain {
+		data, err := up(enc.Data)
+		if err != nil {
+			return nil, fmt.Errorf("engine: can't upgrade %s event from version %d: %w", enc.Type, enc.Version+i, err)
+		}
+		enc.Data = data
+	}
+
+	// decode into a new value, or for pointer types a new pointed-to value
+	ptr := reg.t.Kind() == reflect.Ptr
+	var v reflect.Value
+	if ptr {
+		v = reflect.New(reg.t.Elem())
+	} else {
+		v = reflect.New(reg.t)
+	}
+	if err := reg.codec.Unmarshal(enc.Data, v.Interface()); err != nil {
+		return nil, fmt.Errorf("engine: can't decode %s event: %w", enc.Type, err)
+	}
+	if ptr {
+		return v.Interface(), nil
+	}
+	return v.Elem().Interface(), nil
+}
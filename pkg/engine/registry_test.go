@@ -0,0 +1,267 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type registryEvent struct {
+	Name  string
+	Count int
+}
+
+func TestRegistryRoundTrip(t *testing.T) {
+	r := NewRegistry(JSONCodec)
+	r.Register(registryEvent{})
+	r.Register(&registryEvent{}, Named("registry.ptr"), Using(GobCodec))
+	r.Register(&wrapperspb.StringValue{}, Using(ProtoCodec))
+
+	for _, ev := range []interface{}{
+		registryEvent{"json", 1},
+		&registryEvent{"gob", 2},
+	} {
+		enc, err := r.Encode(ev)
+		if err != nil {
+			t.Fatalf("Encode(%#v): %v", ev, err)
+		}
+		got, err := r.Decode(enc)
+		if err != nil {
+			t.Fatalf("Decode(%q): %v", enc.Type, err)
+		}
+		if !reflect.DeepEqual(got, ev) {
+			t.Errorf("Decode(Encode(%#v)) = %#v", ev, got)
+		}
+	}
+
+	msg := wrapperspb.String("proto")
+	enc, err := r.Encode(msg)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if enc.Type != "*google.golang.org/protobuf/types/known/wrapperspb.StringValue" {
+		t.Errorf("enc.Type = %q, want the TypeName", enc.Type)
+	}
+	got, err := r.Decode(enc)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if m, ok := got.(*wrapperspb.StringValue); !ok || !proto.Equal(m, msg) {
+		t.Errorf("Decode(Encode(%v)) = %v", msg, got)
+	}
+}
+
+func TestRegistryNames(t *testing.T) {
+	r := NewRegistry(JSONCodec)
+	r.Register(registryEvent{}, Named("registry.event"))
+
+	enc, err := r.Encode(registryEvent{})
+	if err != nil || enc.Type != "registry.event" {
+		t.Errorf("Encode() = %q, %v, want type %q", enc.Type, err, "registry.event")
+	}
+	if name, ok := r.Name(reflect.TypeOf(registryEvent{})); !ok || name != "registry.event" {
+		t.Errorf("Name() = %q, %v", name, ok)
+	}
+	if typ, ok := r.Type("registry.event"); !ok || typ != reflect.TypeOf(registryEvent{}) {
+		t.Errorf("Type() = %v, %v", typ, ok)
+	}
+
+	if _, err := r.Encode(testEvent1{}); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("Encode(unregistered) error = %v, want ErrUnknownType", err)
+	}
+	if _, err := r.Decode(EncodedEvent{Type: "nope"}); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("Decode(unregistered) error = %v, want ErrUnknownType", err)
+	}
+}
+
+func TestRegistryDuplicates(t *testing.T) {
+	r := NewRegistry(JSONCodec)
+	r.Register(registryEvent{}, Named("dup"))
+
+	for _, tc := range []struct {
+		ev   interface{}
+		opts []TypeOption
+	}{
+		{registryEvent{}, nil},
+		{testEvent1{}, []TypeOption{Named("dup")}},
+		{nil, nil},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register(%T, %d options) didn't panic", tc.ev, len(tc.opts))
+				}
+			}()
+			r.Register(tc.ev, tc.opts...)
+		}()
+	}
+}
+
+func TestRegistryRedispatch(t *testing.T) {
+	r := NewRegistry(GobCodec)
+	r.Register(registryEvent{})
+
+	enc, err := r.Encode(registryEvent{"again", 3})
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	ev, err := r.Decode(enc)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	b := NewBus()
+	var got registryEvent
+	b.AddListener(func(ev registryEvent) { got = ev })
+	b.Dispatch(ev)
+	if got.Name != "again" || got.Count != 3 {
+		t.Errorf("listener got %+v", got)
+	}
+}
+
+// versionedEvent is the current, third version of an event that had its
+// fields renamed and a field added over time:
+//
+//	version 0: {"Name": ..., "Qty": ...}
+//	version 1: {"Title": ..., "Qty": ...}
+//	version 2: {"Title": ..., "Quantity": ...}
+//	version 3: {"Title": ..., "Quantity": ..., "Unit": ...}
+type versionedEvent struct {
+	Title    string
+	Quantity int
+	Unit     string
+}
+
+func rename(from, to string) Upcaster {
+	return JSONUpcaster(func(obj map[string]interface{}) error {
+		obj[to] = obj[from]
+		delete(obj, from)
+		return nil
+	})
+}
+
+func newVersionedRegistry() *Registry {
+	r := NewRegistry(JSONCodec)
+	r.Upcast("versioned", 0, rename("Name", "Title"))
+	r.Register(versionedEvent{}, Named("versioned"), Version(3))
+	r.Upcast("versioned", 1, rename("Qty", "Quantity"))
+	r.Upcast("versioned", 2, JSONUpcaster(func(obj map[string]interface{}) error {
+		obj["Unit"] = "pcs"
+		return nil
+	}))
+	return r
+}
+
+func TestUpcast(t *testing.T) {
+	r := newVersionedRegistry()
+
+	enc, err := r.Encode(versionedEvent{"new", 5, "kg"})
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if enc.Version != 3 {
+		t.Errorf("enc.Version = %d, want 3", enc.Version)
+	}
+
+	for _, tc := range []struct {
+		enc  EncodedEvent
+		want versionedEvent
+	}{
+		{EncodedEvent{Type: "versioned", Version: 0, Data: []byte(`{"Name":"v0","Qty":1}`)}, versionedEvent{"v0", 1, "pcs"}},
+		{EncodedEvent{Type: "versioned", Version: 1, Data: []byte(`{"Title":"v1","Qty":2}`)}, versionedEvent{"v1", 2, "pcs"}},
+		{EncodedEvent{Type: "versioned", Version: 2, Data: []byte(`{"Title":"v2","Quantity":3}`)}, versionedEvent{"v2", 3, "pcs"}},
+		{enc, versionedEvent{"new", 5, "kg"}},
+	} {
+		got, err := r.Decode(tc.enc)
+		if err != nil {
+			t.Errorf("Decode(version %d): %v", tc.enc.Version, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("Decode(version %d) = %+v, want %+v", tc.enc.Version, got, tc.want)
+		}
+	}
+}
+
+func TestUpcastConcurrent(t *testing.T) {
+	r := newVersionedRegistry()
+	name := TypeName(reflect.TypeOf(registryEvent{}))
+	r.Register(registryEvent{}, Version(10))
+
+	started := make(chan struct{})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		close(started)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				r.Decode(EncodedEvent{Type: name, Data: []byte(`{}`)})
+			}
+		}
+	}()
+	<-started
+	for v := 0; v < 10; v++ {
+		r.Upcast(name, v, rename("a", "b"))
+		runtime.Gosched()
+	}
+	close(stop)
+	<-done
+
+	if _, err := r.Decode(EncodedEvent{Type: name, Data: []byte(`{}`)}); err != nil {
+		t.Errorf("Decode: %v", err)
+	}
+}
+
+func TestUpcastErrors(t *testing.T) {
+	r := newVersionedRegistry()
+	r.Register(registryEvent{}, Version(2))
+	r.Upcast(TypeName(reflect.TypeOf(registryEvent{})), 0, func(data []byte) ([]byte, error) {
+		return nil, errors.New("broken")
+	})
+
+	for _, enc := range []EncodedEvent{
+		{Type: "versioned", Version: 4, Data: []byte(`{}`)},           // from the future
+		{Type: "versioned", Version: 0, Data: []byte(`not json`)},     // upcaster fails
+		{Type: TypeName(reflect.TypeOf(registryEvent{})), Version: 1}, // no upcaster
+		{Type: TypeName(reflect.TypeOf(registryEvent{})), Version: 0}, // upcaster fails
+	} {
+		if ev, err := r.Decode(enc); err == nil {
+			t.Errorf("Decode(%s version %d) = %+v, want an error", enc.Type, enc.Version, ev)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering an upcaster twice didn't panic")
+		}
+	}()
+	r.Upcast("versioned", 1, rename("a", "b"))
+}
@@ -0,0 +1,48 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import "context"
+
+type replayKey struct{}
+
+// WithReplay returns a copy of ctx marking the events dispatched with it as
+// replayed from a recording, rather than happening now. Listeners with side
+// effects outside the process, such as writing to syslog, should check
+// IsReplay and skip replayed events.
+//
+// If env is not nil, it becomes the Envelope of the context, so that
+// listeners see the envelope the event was recorded with. On a Bus with
+// envelopes enabled, the replayed dispatch gets a new Envelope caused by env.
+func WithReplay(ctx context.Context, env *Envelope) context.Context {
+	ctx = context.WithValue(ctx, replayKey{}, true)
+	if env != nil {
+		ctx = context.WithValue(ctx, envelopeKey{}, env)
+	}
+	return ctx
+}
+
+// IsReplay reports whether ctx belongs to a replayed event, see WithReplay.
+// Events dispatched by the listeners of a replayed event are replayed too.
+func IsReplay(ctx context.Context) bool {
+	replay, _ := ctx.Value(replayKey{}).(bool)
+	return replay
+}
@@ -0,0 +1,90 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type countingJournal struct {
+	n int
+}
+
+func (j *countingJournal) Append(ctx context.Context, ev interface{}) error {
+	j.n++
+	return nil
+}
+
+func TestReplay(t *testing.T) {
+	b := NewBus()
+	j := &countingJournal{}
+	b.Use(Journaled(j))
+
+	var replays []bool
+	b.AddListener(func(ctx context.Context, ev testEvent1) {
+		replays = append(replays, IsReplay(ctx))
+		b.DispatchContext(ctx, testEvent2{})
+	})
+	b.AddListener(func(ctx context.Context, ev testEvent2) {
+		replays = append(replays, IsReplay(ctx))
+	})
+
+	b.Dispatch(testEvent1{})
+	if j.n != 2 {
+		t.Errorf("journaled %d events, want 2", j.n)
+	}
+	b.DispatchContext(WithReplay(context.Background(), nil), testEvent1{})
+	if j.n != 2 {
+		t.Errorf("journaled %d events after replay, want 2", j.n)
+	}
+
+	want := []bool{false, false, true, true}
+	if len(replays) != len(want) {
+		t.Fatalf("listeners called %d times, want %d", len(replays), len(want))
+	}
+	for i := range want {
+		if replays[i] != want[i] {
+			t.Errorf("IsReplay() in call %d = %v, want %v", i, replays[i], want[i])
+		}
+	}
+}
+
+func TestReplayEnvelope(t *testing.T) {
+	recorded := &Envelope{ID: "recorded", CorrelationID: "op"}
+
+	b := NewBus()
+	var got *Envelope
+	b.AddListener(func(ctx context.Context, ev testEvent1) {
+		got, _ = EnvelopeFromContext(ctx)
+	})
+	b.DispatchContext(WithReplay(context.Background(), recorded), testEvent1{})
+	if got != recorded {
+		t.Errorf("listener got envelope %+v, want the recorded one", got)
+	}
+
+	b.EnableEnvelopes(newTestClock(time.Now()))
+	b.DispatchContext(WithReplay(context.Background(), recorded), testEvent1{})
+	if got.CausationID != "recorded" || got.CorrelationID != "op" {
+		t.Errorf("listener got envelope %+v, want one caused by the recorded one", got)
+	}
+}
@@ -0,0 +1,254 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ErrNoResponders is returned by Request and RequestAll if no responder
+// received the request.
+var ErrNoResponders = errors.New("engine: no responders")
+
+// DefaultRequestTimeout limits how long Request and RequestAll wait for
+// replies if their context has no deadline.
+const DefaultRequestTimeout = 10 * time.Second
+
+type (
+	requestKey   struct{}
+	requestIDKey struct{}
+	replyKey     struct{}
+)
+
+// reply is the answer of a single responder.
+type reply struct {
+	responder string
+	value     interface{}
+	err       error
+}
+
+// collector gathers the replies to a request. Bus.deliver counts the
+// responders the request is delivered to, so that the requester knows how
+// many replies to wait for, on synchronous and asynchronous buses alike.
+type collector struct {
+	id      string
+	reqType reflect.Type  // dynamic type of the request
+	changed chan struct{} // signalled when a reply arrives
+
+	mu       sync.Mutex // protects the fields below
+	expected int
+	replies  []reply
+}
+
+// expectReply counts a responder the request in ctx is delivered to, and
+// returns the context to call the responder with. It returns ctx unchanged
+// if ctx doesn't belong to a request for ev.
+func expectReply(ctx context.Context, ev interface{}) context.Context {
+	c, _ := ctx.Value(requestKey{}).(*collector)
+	if c == nil || c.reqType != reflect.TypeOf(ev) {
+		return ctx
+	}
+	c.mu.Lock()
+	c.expected++
+	c.mu.Unlock()
+	return context.WithValue(ctx, replyKey{}, c)
+}
+
+// withdrawReply uncounts the responder ctx was meant for, if any, when the
+// request is dropped before reaching it.
+func withdrawReply(ctx context.Context) {
+	c, _ := ctx.Value(replyKey{}).(*collector)
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.expected--
+	c.mu.Unlock()
+	c.signal()
+}
+
+func (c *collector) add(r reply) {
+	c.mu.Lock()
+	c.replies = append(c.replies, r)
+	c.mu.Unlock()
+	c.signal()
+}
+
+// signal wakes up the requester waiting for replies.
+func (c *collector) signal() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// RequestID returns the ID of the request ctx belongs to, if any. Responders
+// receive it in their context, as do the listeners of events they dispatch
+// with it. If envelopes are enabled, the ID is also the CorrelationID of a
+// request that isn't caused by another event.
+func RequestID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+// Respond registers fn on bus to answer requests of type Q sent with Request
+// or RequestAll with replies of type R. An error returned by fn is passed to
+// the requester rather than to the error handler of bus. Events of type Q
+// dispatched without a request are ignored.
+//
+// For example:
+//
+//	engine.Respond(bus, func(ctx context.Context, q OwnerQuery) (Owner, error) {
+//		return owners.Lookup(q.Engine)
+//	})
+//
+//	owner, err := engine.Request[OwnerQuery, Owner](ctx, bus, OwnerQuery{Engine: "x"})
+func Respond[Q, R any](bus *Bus, fn func(ctx context.Context, req Q) (R, error), opts ...ListenerOption) *Subscription {
+	name := funcName(reflect.ValueOf(fn))
+	return bus.register(&listener{
+		handle: func(ctx context.Context, ev interface{}) error {
+			c, _ := ctx.Value(replyKey{}).(*collector)
+			if c == nil {
+				return nil
+			}
+			// events dispatched by fn don't belong to the request
+			ctx = context.WithValue(ctx, requestKey{}, (*collector)(nil))
+			ctx = context.WithValue(ctx, replyKey{}, (*collector)(nil))
+
+			answered := false
+			defer func() {
+				if !answered {
+					// let the requester know fn panicked; the Bus reports it
+					c.add(reply{responder: name, err: errors.New("responder panicked")})
+				}
+			}()
+			v, err := fn(ctx, ev.(Q))
+			answered = true
+			c.add(reply{responder: name, value: v, err: err})
+			return nil
+		},
+		name:      name,
+		evType:    typeOf[Q](),
+		responder: true,
+	}, opts)
+}
+
+// Request dispatches req on bus and returns the first successful reply of
+// type R from the responders registered with Respond. If all responders
+// fail, it returns the ListenerErrors describing their failures. It returns
+// ErrNoResponders if nobody received the request, and the context's error if
+// no successful reply arrives before ctx is done; without a deadline, ctx
+// times out after DefaultRequestTimeout.
+func Request[Q, R any](ctx context.Context, bus *Bus, req Q) (R, error) {
+	var zero R
+	values, failed, err := ask[R](ctx, bus, req, true)
+	if len(values) > 0 {
+		return values[0], nil
+	}
+	if err != nil {
+		return zero, err
+	}
+	return zero, failed
+}
+
+// RequestAll dispatches req on bus and waits for the replies of all
+// responders that received it, like Request. It returns the successful
+// replies of type R in the order they arrived. The error is the
+// ListenerErrors of the responders that failed, if any, or the context's
+// error if not all replies arrived in time; the replies received until then
+// are returned with it.
+func RequestAll[Q, R any](ctx context.Context, bus *Bus, req Q) ([]R, error) {
+	values, failed, err := ask[R](ctx, bus, req, false)
+	if err != nil {
+		return values, err
+	}
+	if len(failed) > 0 {
+		return values, failed
+	}
+	return values, nil
+}
+
+// ask dispatches req and collects the replies of type R, until all
+// responders have replied or, if first is set, a successful reply arrives.
+func ask[R any](ctx context.Context, bus *Bus, req interface{}, first bool) ([]R, ListenerErrors, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+		defer cancel()
+	}
+
+	c := &collector{
+		id:      newID(),
+		reqType: reflect.TypeOf(req),
+		changed: make(chan struct{}, 1),
+	}
+	ctx = context.WithValue(ctx, requestKey{}, c)
+	ctx = context.WithValue(ctx, requestIDKey{}, c.id)
+	if _, ok := EnvelopeFromContext(ctx); !ok && ctx.Value(correlationKey{}) == nil {
+		ctx = WithCorrelationID(ctx, c.id)
+	}
+	dispatchErr := bus.DispatchContext(ctx, req)
+
+	var (
+		values []R
+		failed ListenerErrors
+		seen   int
+	)
+	for {
+		c.mu.Lock()
+		replies, expected := c.replies, c.expected
+		c.mu.Unlock()
+
+		for _, r := range replies[seen:] {
+			switch v, ok := r.value.(R); {
+			case r.err != nil:
+				failed = append(failed, &ListenerError{Listener: r.responder, Event: req, Err: r.err})
+			case !ok:
+				err := fmt.Errorf("reply of type %T, want %v", r.value, typeOf[R]())
+				failed = append(failed, &ListenerError{Listener: r.responder, Event: req, Err: err})
+			default:
+				values = append(values, v)
+			}
+		}
+		seen = len(replies)
+
+		if expected == 0 {
+			if dispatchErr != nil {
+				return nil, nil, dispatchErr
+			}
+			return nil, nil, ErrNoResponders
+		}
+		if seen == expected || (first && len(values) > 0) {
+			return values, failed, nil
+		}
+
+		select {
+		case <-c.changed:
+		case <-ctx.Done():
+			return values, failed, ctx.Err()
+		}
+	}
+}
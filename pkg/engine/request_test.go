@@ -0,0 +1,170 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type ownerQuery struct {
+	Engine string
+}
+
+type owner string
+
+func TestRequest(t *testing.T) {
+	b := NewBus()
+	Respond(b, func(ctx context.Context, q ownerQuery) (owner, error) {
+		return owner("alice owns " + q.Engine), nil
+	})
+
+	got, err := Request[ownerQuery, owner](context.Background(), b, ownerQuery{"x"})
+	if err != nil || got != "alice owns x" {
+		t.Errorf("Request() = %q, %v, want %q", got, err, "alice owns x")
+	}
+
+	// a plain dispatch gets no reply, and doesn't fail
+	if err := b.DispatchContext(context.Background(), ownerQuery{"x"}); err != nil {
+		t.Errorf("DispatchContext() = %v", err)
+	}
+}
+
+func TestRequestNoResponders(t *testing.T) {
+	b := NewBus()
+	b.AddListener(func(q ownerQuery) {})
+
+	if _, err := Request[ownerQuery, owner](context.Background(), b, ownerQuery{"x"}); err != ErrNoResponders {
+		t.Errorf("Request() error = %v, want ErrNoResponders", err)
+	}
+	if _, err := RequestAll[ownerQuery, owner](context.Background(), b, ownerQuery{"x"}); err != ErrNoResponders {
+		t.Errorf("RequestAll() error = %v, want ErrNoResponders", err)
+	}
+}
+
+func TestRequestMultipleResponders(t *testing.T) {
+	b := NewBus()
+	b.SetErrorHandler(func(*ListenerError) {})
+	answer := func(o owner) func(context.Context, ownerQuery) (owner, error) {
+		return func(context.Context, ownerQuery) (owner, error) { return o, nil }
+	}
+	Respond(b, answer("low"), Priority(-1))
+	Respond(b, func(context.Context, ownerQuery) (owner, error) { return "", errors.New("not mine") }, Priority(2))
+	Respond(b, answer("high"), Priority(1))
+	Respond(b, func(context.Context, ownerQuery) (string, error) { return "wrong type", nil })
+	Respond(b, func(context.Context, ownerQuery) (owner, error) { panic("boom") })
+
+	got, err := Request[ownerQuery, owner](context.Background(), b, ownerQuery{"x"})
+	if err != nil || got != "high" {
+		t.Errorf("Request() = %q, %v, want %q", got, err, "high")
+	}
+
+	all, err := RequestAll[ownerQuery, owner](context.Background(), b, ownerQuery{"x"})
+	if len(all) != 2 || all[0] != "high" || all[1] != "low" {
+		t.Errorf("RequestAll() = %q, want [high low]", all)
+	}
+	var failed ListenerErrors
+	if !errors.As(err, &failed) || len(failed) != 3 {
+		t.Fatalf("RequestAll() error = %v, want 3 failures", err)
+	}
+	if failed[0].Err.Error() != "not mine" || failed[0].Event != (ownerQuery{"x"}) {
+		t.Errorf("failed[0] = %v", failed[0])
+	}
+}
+
+func TestRequestAllResponderErrors(t *testing.T) {
+	b := NewBus()
+	Respond(b, func(context.Context, ownerQuery) (owner, error) { return "", errors.New("not mine") })
+
+	_, err := Request[ownerQuery, owner](context.Background(), b, ownerQuery{"x"})
+	var failed ListenerErrors
+	if !errors.As(err, &failed) || len(failed) != 1 {
+		t.Errorf("Request() error = %v, want 1 failure", err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	b := NewBus(Async(1, Block))
+	defer b.Close()
+	release := make(chan struct{})
+	Respond(b, func(context.Context, ownerQuery) (owner, error) {
+		<-release
+		return "late", nil
+	})
+	Respond(b, func(context.Context, ownerQuery) (owner, error) { return "early", nil })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	all, err := RequestAll[ownerQuery, owner](ctx, b, ownerQuery{"x"})
+	if err != context.DeadlineExceeded || len(all) != 1 || all[0] != "early" {
+		t.Errorf("RequestAll() = %q, %v, want [early], DeadlineExceeded", all, err)
+	}
+	close(release)
+}
+
+func TestRequestAsyncDropped(t *testing.T) {
+	b := NewBus(Async(1, DropNewest))
+	defer b.Close()
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	Respond(b, func(context.Context, ownerQuery) (owner, error) {
+		started <- struct{}{}
+		<-release
+		return "busy", nil
+	})
+
+	// occupy the responder and fill its queue
+	go RequestAll[ownerQuery, owner](context.Background(), b, ownerQuery{"1"})
+	<-started
+	b.Dispatch(ownerQuery{"fill"})
+
+	if _, err := Request[ownerQuery, owner](context.Background(), b, ownerQuery{"2"}); err != ErrNoResponders {
+		t.Errorf("Request() error = %v, want ErrNoResponders", err)
+	}
+	close(release)
+}
+
+func TestRequestID(t *testing.T) {
+	b := NewBus()
+	b.EnableEnvelopes(newTestClock(time.Now()))
+
+	var id string
+	var env *Envelope
+	b.AddListener(func(ctx context.Context, o owner) {
+		id, _ = RequestID(ctx)
+		env, _ = EnvelopeFromContext(ctx)
+	})
+	Respond(b, func(ctx context.Context, q ownerQuery) (owner, error) {
+		b.DispatchContext(ctx, owner("notification"))
+		return "alice", nil
+	})
+
+	if _, err := Request[ownerQuery, owner](context.Background(), b, ownerQuery{"x"}); err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if id == "" {
+		t.Fatalf("listener got no request ID")
+	}
+	if env == nil || env.CorrelationID != id {
+		t.Errorf("listener got envelope %+v, want correlation ID %q", env, id)
+	}
+}
@@ -0,0 +1,305 @@
+package syslogger
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StructuredSyslogger is implemented by events that provide an RFC 5424
+// message with structured data, in addition to the plain message of their
+// Syslog method. The structured message is used when a Writer has been set
+// with SetWriter; otherwise the event is sent to syslog as a plain message.
+type StructuredSyslogger interface {
+	Syslogger
+	// StructuredSyslog should return the message to send to syslog.
+	StructuredSyslog() Message
+}
+
+// Message is an RFC 5424 syslog message. Empty fields are filled in by the
+// Writer.
+type Message struct {
+	// Severity is the severity of the message (not a facility).
+	Severity syslog.Priority
+	// Timestamp is the time of the event. It defaults to the current time.
+	Timestamp time.Time
+	// AppName identifies the application; it defaults to Writer.AppName.
+	AppName string
+	// MsgID identifies the type of message, such as "TCPIN" or "LOGIN".
+	MsgID string
+	// StructuredData holds the fields of the event, to be indexed by the
+	// log pipeline.
+	StructuredData []SDElement
+	// Msg is the free-form message.
+	Msg string
+}
+
+// SDElement is an element of the structured data of a Message.
+type SDElement struct {
+	// ID names the element, such as "origin" or a private ID in the form
+	// "name@<enterprise number>".
+	ID string
+	// Params are the parameters of the element, in order.
+	Params []SDParam
+}
+
+// SDParam is a parameter of an SDElement.
+type SDParam struct {
+	Name, Value string
+}
+
+// DefaultWriteTimeout is the WriteTimeout of new Writers.
+const DefaultWriteTimeout = 5 * time.Second
+
+// Writer sends RFC 5424 messages to a syslog receiver.
+type Writer struct {
+	// Facility is the facility of the messages, LOG_USER by default.
+	Facility syslog.Priority
+	// Hostname, AppName and ProcID identify the sender. They default to the
+	// host name, the base name of the program and the process ID.
+	Hostname, AppName, ProcID string
+	// OctetCounting frames messages with their length, as required for
+	// stream transports such as TCP (RFC 6587). Otherwise each message is
+	// written with a single Write call, as required for datagrams.
+	OctetCounting bool
+	// WriteTimeout limits how long writing a message to a network
+	// connection may block, so that a stalled receiver doesn't hold up
+	// dispatching. Zero means no limit.
+	WriteTimeout time.Duration
+
+	mu   sync.Mutex // serializes writes
+	w    io.Writer
+	dial func() (net.Conn, error) // reconnects a Writer returned by Dial
+}
+
+// NewWriter returns a Writer writing messages to w, with default settings.
+func NewWriter(w io.Writer) *Writer {
+	hostname, _ := os.Hostname()
+	return &Writer{
+		Facility:     syslog.LOG_USER,
+		Hostname:     hostname,
+		AppName:      filepath.Base(os.Args[0]),
+		ProcID:       strconv.Itoa(os.Getpid()),
+		WriteTimeout: DefaultWriteTimeout,
+		w:            w,
+	}
+}
+
+// Dial returns a Writer connected to the syslog receiver at raddr. If network
+// is empty, it connects to the local syslog daemon. Messages sent over a
+// stream network such as "tcp" use octet counting. Like log/syslog, the
+// Writer reconnects if writing a message fails, e.g. after the receiver
+// restarted, and retries the message once.
+func Dial(network, raddr string) (*Writer, error) {
+	dial := dialLocal
+	if network != "" {
+		dial = func() (net.Conn, error) { return net.Dial(network, raddr) }
+	}
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	w := NewWriter(conn)
+	w.OctetCounting = strings.HasPrefix(network, "tcp") || network == "unix"
+	w.dial = dial
+	return w, nil
+}
+
+// dialLocal connects to the local syslog daemon through its Unix socket.
+func dialLocal() (net.Conn, error) {
+	for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+		if conn, err := net.Dial("unixgram", path); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("can't connect to local syslog daemon")
+}
+
+// WriteMessage sends m to the receiver.
+func (w *Writer) WriteMessage(m Message) error {
+	if m.Severity < syslog.LOG_EMERG || m.Severity > syslog.LOG_DEBUG {
+		return fmt.Errorf("invalid syslog severity: %v", m.Severity)
+	}
+	if m.Timestamp.IsZero() {
+		m.Timestamp = time.Now()
+	}
+	if m.AppName == "" {
+		m.AppName = w.AppName
+	}
+	msg := w.Format(m)
+	if w.OctetCounting {
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.write(msg)
+	if err != nil && w.dial != nil {
+		if conn, derr := w.dial(); derr == nil {
+			w.w.(io.Closer).Close()
+			w.w = conn
+			err = w.write(msg)
+		}
+	}
+	return err
+}
+
+// write writes msg within WriteTimeout. w.mu must be held.
+func (w *Writer) write(msg string) error {
+	if conn, ok := w.w.(net.Conn); ok && w.WriteTimeout > 0 {
+		if err := conn.SetWriteDeadline(time.Now().Add(w.WriteTimeout)); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w.w, msg)
+	return err
+}
+
+// Close closes the underlying connection, if it can be closed. A Writer
+// returned by Dial no longer reconnects once closed.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dial = nil
+	if c, ok := w.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Format returns m formatted as an RFC 5424 message from w, without framing.
+// Fields of m are used as is: an empty field is written as the nil value.
+func (w *Writer) Format(m Message) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<%d>1 ", w.Facility&^0x07|m.Severity&0x07)
+	if m.Timestamp.IsZero() {
+		sb.WriteString("-")
+	} else {
+		sb.WriteString(m.Timestamp.Format("2006-01-02T15:04:05.000000Z07:00"))
+	}
+	for _, f := range []struct {
+		value string
+		max   int
+	}{
+		{w.Hostname, 255},
+		{m.AppName, 48},
+		{w.ProcID, 128},
+		{m.MsgID, 32},
+	} {
+		sb.WriteByte(' ')
+		sb.WriteString(headerField(f.value, f.max))
+	}
+
+	sb.WriteByte(' ')
+	if len(m.StructuredData) == 0 {
+		sb.WriteString("-")
+	}
+	for _, el := range m.StructuredData {
+		sb.WriteByte('[')
+		sb.WriteString(sdName(el.ID))
+		for _, p := range el.Params {
+			sb.WriteByte(' ')
+			sb.WriteString(sdName(p.Name))
+			sb.WriteString(`="`)
+			sdEscaper.WriteString(&sb, p.Value)
+			sb.WriteByte('"')
+		}
+		sb.WriteByte(']')
+	}
+
+	if m.Msg != "" {
+		sb.WriteByte(' ')
+		sb.WriteString(m.Msg)
+	}
+	return sb.String()
+}
+
+// headerField returns value as a header field of at most max printable ASCII
+// characters, or the nil value "-" if it is empty.
+func headerField(value string, max int) string {
+	if value == "" {
+		return "-"
+	}
+	b := []byte(value)
+	if len(b) > max {
+		b = b[:max]
+	}
+	for i, c := range b {
+		if c < 33 || c > 126 {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// sdName returns name as an SD-ID or PARAM-NAME, which are limited to 32
+// printable ASCII characters other than '=', ' ', ']' and '"'.
+func sdName(name string) string {
+	b := []byte(headerField(name, 32))
+	for i, c := range b {
+		if c == '=' || c == ']' || c == '"' {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// sdEscaper escapes the characters of a PARAM-VALUE that must be escaped.
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+var (
+	structuredMu sync.RWMutex
+	structured   *Writer
+)
+
+// SetWriter makes the listener send events to syslog through w, as RFC 5424
+// messages, instead of through package log/syslog. Events implementing
+// StructuredSyslogger are sent with their structured data. Passing nil
+// restores the default.
+func SetWriter(w *Writer) {
+	structuredMu.Lock()
+	defer structuredMu.Unlock()
+	structured = w
+}
+
+func structuredWriter() *Writer {
+	structuredMu.RLock()
+	defer structuredMu.RUnlock()
+	return structured
+}
+
+// message returns the RFC 5424 message for ev.
+func message(ev Syslogger) Message {
+	if sev, ok := ev.(StructuredSyslogger); ok {
+		return sev.StructuredSyslog()
+	}
+	sev, msg := ev.Syslog()
+	return Message{Severity: sev, Msg: msg}
+}
@@ -0,0 +1,210 @@
+package syslogger
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"bytes"
+	"log/syslog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhojpur/events/pkg/engine"
+)
+
+type StructuredTestEvent struct {
+	TestEvent
+}
+
+func (ev *StructuredTestEvent) StructuredSyslog() Message {
+	return Message{
+		Severity:  syslog.LOG_NOTICE,
+		Timestamp: time.Date(2018, 1, 2, 3, 4, 5, 6000, time.UTC),
+		MsgID:     "ENGINE",
+		StructuredData: []SDElement{
+			{ID: "engine@32473", Params: []SDParam{{Name: "name", Value: "build-1"}, {Name: "phase", Value: `"done" [ok]\`}}},
+			{ID: "origin", Params: []SDParam{{Name: "software", Value: "events"}}},
+		},
+		Msg: "engine done",
+	}
+}
+
+var _ StructuredSyslogger = (*StructuredTestEvent)(nil) // compile-time interface check
+
+func testWriter(buf *bytes.Buffer) *Writer {
+	w := NewWriter(buf)
+	w.Hostname, w.AppName, w.ProcID = "host", "events", "42"
+	return w
+}
+
+func TestFormat(t *testing.T) {
+	w := testWriter(nil)
+	w.Facility = syslog.LOG_LOCAL0
+
+	for _, tc := range []struct {
+		m    Message
+		want string
+	}{
+		{
+			(&StructuredTestEvent{}).StructuredSyslog(),
+			`<133>1 2018-01-02T03:04:05.000006Z host - 42 ENGINE [engine@32473 name="build-1" phase="\"done\" [ok\]\\"][origin software="events"] engine done`,
+		},
+		{
+			Message{Severity: syslog.LOG_ERR, AppName: "my app", Msg: "failed"},
+			`<131>1 - host my_app 42 - - failed`,
+		},
+		{
+			Message{Severity: syslog.LOG_DEBUG, MsgID: strings.Repeat("x", 40), StructuredData: []SDElement{{ID: "a=b"}}},
+			`<135>1 - host - 42 ` + strings.Repeat("x", 32) + ` [a_b]`,
+		},
+	} {
+		if got := w.Format(tc.m); got != tc.want {
+			t.Errorf("Format() =\n%s\nwant\n%s", got, tc.want)
+		}
+	}
+}
+
+func TestWriteMessage(t *testing.T) {
+	var buf bytes.Buffer
+	w := testWriter(&buf)
+	w.OctetCounting = true
+
+	ts := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := w.WriteMessage(Message{Severity: syslog.LOG_INFO, Timestamp: ts, Msg: "hi"}); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	msg := "<14>1 2018-01-02T03:04:05.000000Z host events 42 - - hi"
+	if got, want := buf.String(), "55 "+msg; got != want || len(msg) != 55 {
+		t.Errorf("WriteMessage() wrote %q, want %q", got, want)
+	}
+
+	if err := w.WriteMessage(Message{Severity: syslog.Priority(123)}); err == nil {
+		t.Errorf("WriteMessage() accepted an invalid severity")
+	}
+}
+
+func TestStructuredListener(t *testing.T) {
+	var buf bytes.Buffer
+	SetWriter(testWriter(&buf))
+	defer SetWriter(nil)
+
+	engine.Dispatch(&StructuredTestEvent{})
+	if got := buf.String(); !strings.Contains(got, ` events 42 ENGINE [engine@32473 name="build-1"`) {
+		t.Errorf("structured event written as %q", got)
+	}
+
+	buf.Reset()
+	ev := &TestEvent{priority: syslog.LOG_WARNING, message: "plain"}
+	engine.Dispatch(ev)
+	if got := buf.String(); !strings.HasPrefix(got, "<12>1 ") || !strings.HasSuffix(got, " host events 42 - - plain") {
+		t.Errorf("plain event written as %q", got)
+	}
+}
+
+func TestDial(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+	}()
+
+	w, err := Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer w.Close()
+	if !w.OctetCounting {
+		t.Errorf("TCP writer doesn't use octet counting")
+	}
+	if err := w.WriteMessage(Message{Severity: syslog.LOG_INFO, Msg: "over tcp\n"}); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	select {
+	case got := <-received:
+		if !strings.HasSuffix(got, " over tcp\n") {
+			t.Errorf("received %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("nothing received")
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	w := NewWriter(conn)
+	defer w.Close()
+	w.WriteTimeout = 10 * time.Millisecond
+
+	// nothing reads from peer, so the write can't complete
+	err := w.WriteMessage(Message{Severity: syslog.LOG_INFO, Msg: "stalled"})
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("WriteMessage() error = %v, want a timeout", err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	conn, peer := net.Pipe()
+	peer.Close() // the receiver went away
+	w := NewWriter(conn)
+	defer w.Close()
+
+	received := make(chan string, 1)
+	w.dial = func() (net.Conn, error) {
+		conn, peer := net.Pipe()
+		go func() {
+			defer peer.Close()
+			buf := make([]byte, 1024)
+			n, _ := peer.Read(buf)
+			received <- string(buf[:n])
+		}()
+		return conn, nil
+	}
+
+	if err := w.WriteMessage(Message{Severity: syslog.LOG_INFO, Msg: "again"}); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	select {
+	case got := <-received:
+		if !strings.HasSuffix(got, " again") {
+			t.Errorf("received %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("nothing received after reconnecting")
+	}
+
+	w.Close()
+	if err := w.WriteMessage(Message{Severity: syslog.LOG_INFO, Msg: "closed"}); err == nil {
+		t.Errorf("WriteMessage() on a closed Writer succeeded")
+	}
+}
@@ -49,9 +49,41 @@ which severity it should have (see package "log/syslog" for details).
 
 The compile-time interface check is optional but recommended because usually
 there is no other static conversion in these cases.
+
+By default, messages are sent in the legacy BSD format of package log/syslog.
+Use SetWriter to send RFC 5424 messages instead, for example to a remote
+receiver:
+
+	w, err := syslogger.Dial("tcp", "logs.example.com:601")
+	if err != nil {
+		...
+	}
+	syslogger.SetWriter(w)
+
+Events can then also implement StructuredSyslogger, to add structured data
+and a message ID that the log pipeline can index:
+
+	func (ev *MyEvent) StructuredSyslog() syslogger.Message {
+		return syslogger.Message{
+			Severity: syslog.LOG_INFO,
+			MsgID:    "MYEVENT",
+			StructuredData: []syslogger.SDElement{{
+				ID:     "myevent@32473",
+				Params: []syslogger.SDParam{
+					{Name: "field1", Value: ev.field1},
+					{Name: "field2", Value: ev.field2},
+				},
+			}},
+			Msg: "my event happened",
+		}
+	}
+
+Events replayed from a recording (see engine.IsReplay) are not sent to syslog
+again.
 */
 
 import (
+	"context"
 	"fmt"
 	"log/syslog"
 	"os"
@@ -82,7 +114,19 @@ type syslogWriter interface {
 // writer holds a persistent connection to the syslog daemon
 var writer syslogWriter
 
-func listener(ev Syslogger) {
+func listener(ctx context.Context, ev Syslogger) {
+	// Replayed events were sent to syslog when they first happened.
+	if engine.IsReplay(ctx) {
+		return
+	}
+
+	if sw := structuredWriter(); sw != nil {
+		if err := sw.WriteMessage(message(ev)); err != nil {
+			log.Errorf("can't write syslog event: %v", err)
+		}
+		return
+	}
+
 	// Ask the event to convert itself to a syslog message.
 	sev, msg := ev.Syslog()
 
@@ -21,6 +21,7 @@ package syslogger
 // THE SOFTWARE.
 
 import (
+	"context"
 	"fmt"
 	"log/syslog"
 	"strings"
@@ -133,6 +134,18 @@ func TestSyslog(t *testing.T) {
 	}
 }
 
+func TestSyslogSkipsReplay(t *testing.T) {
+	fw := &fakeWriter{}
+	writer = fw
+
+	ev := &TestEvent{priority: syslog.LOG_INFO, message: "replayed"}
+	engine.DispatchContext(engine.WithReplay(context.Background(), nil), ev)
+
+	if ev.triggered || fw.message != "" {
+		t.Errorf("replayed event was sent to syslog")
+	}
+}
+
 // TestBadWriter verifies we are still triggering (to normal logs) if
 // the syslog connection failed
 func TestBadWriter(t *testing.T) {
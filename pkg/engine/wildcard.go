@@ -0,0 +1,77 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"reflect"
+)
+
+// EventInfo describes a dispatched event to wildcard listeners.
+type EventInfo struct {
+	// Type is the name of the event's dynamic type, see TypeName.
+	Type string
+	// Listeners is the number of listeners, other than wildcard listeners,
+	// that matched the event's type. Some of them may have skipped the
+	// event because of their filters.
+	Listeners int
+}
+
+// eventInfoKey is the context key under which wildcard listeners get their
+// EventInfo.
+type eventInfoKey struct{}
+
+// SubscribeAll registers fn on bus as a wildcard listener, which receives
+// every event dispatched on bus regardless of its type, together with an
+// EventInfo describing it. This is meant for cross-cutting observers such as
+// audit logs, debugging taps or recorders. Wildcard listeners are ordered
+// with the other listeners by priority and registration order, and filters
+// apply to them; Where skips events without the field. Match modes have no
+// effect.
+//
+// Unlike listeners for interface{}, wildcard listeners are not part of the
+// count in EventInfo.Listeners.
+func SubscribeAll(bus *Bus, fn func(ctx context.Context, ev interface{}, info EventInfo) error, opts ...ListenerOption) *Subscription {
+	return bus.register(&listener{
+		handle: func(ctx context.Context, ev interface{}) error {
+			return fn(ctx, ev, *ctx.Value(eventInfoKey{}).(*EventInfo))
+		},
+		name: funcName(reflect.ValueOf(fn)),
+	}, opts)
+}
+
+// TypeName returns the name used to identify the event type t: the import
+// path of its package followed by its name, e.g.
+// "github.com/bhojpur/events/pkg/status.StatusUpdater", with a "*" prefix for
+// each level of pointer indirection. Unnamed types are identified by their
+// Go syntax, as returned by t.String().
+func TypeName(t reflect.Type) string {
+	if t == nil {
+		return "nil"
+	}
+	if t.Kind() == reflect.Ptr {
+		return "*" + TypeName(t.Elem())
+	}
+	if t.Name() == "" || t.PkgPath() == "" {
+		return t.String()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
@@ -0,0 +1,165 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSubscribeAll(t *testing.T) {
+	b := NewBus()
+
+	var events []interface{}
+	var infos []EventInfo
+	SubscribeAll(b, func(ctx context.Context, ev interface{}, info EventInfo) error {
+		events = append(events, ev)
+		infos = append(infos, info)
+		return nil
+	})
+	b.AddListener(func(testEvent1) {})
+	b.AddListener(func(testInterface1) {})
+
+	b.Dispatch(testEvent1{})
+	b.Dispatch("string event")
+	b.Dispatch(&testEvent2{})
+
+	wantEvents := []interface{}{testEvent1{}, "string event", &testEvent2{}}
+	if !reflect.DeepEqual(events, wantEvents) {
+		t.Errorf("wildcard listener got %v, want %v", events, wantEvents)
+	}
+	wantInfos := []EventInfo{
+		{Type: "github.com/bhojpur/events/pkg/engine.testEvent1", Listeners: 2},
+		{Type: "string", Listeners: 0},
+		{Type: "*github.com/bhojpur/events/pkg/engine.testEvent2", Listeners: 0},
+	}
+	if !reflect.DeepEqual(infos, wantInfos) {
+		t.Errorf("wildcard listener got %v, want %v", infos, wantInfos)
+	}
+}
+
+func TestSubscribeAllOrderAndFilter(t *testing.T) {
+	b := NewBus()
+
+	var order []string
+	b.AddListener(func(testEvent1) { order = append(order, "typed") })
+	SubscribeAll(b, func(ctx context.Context, ev interface{}, info EventInfo) error {
+		order = append(order, "wildcard")
+		return nil
+	}, Priority(1), Filter(func(ev interface{}) bool {
+		_, ok := ev.(testEvent1)
+		return ok
+	}))
+
+	b.Dispatch(testEvent1{})
+	b.Dispatch(testEvent2{})
+
+	if want := []string{"wildcard", "typed"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("listeners called in order %v, want %v", order, want)
+	}
+}
+
+func TestSubscribeAllWhere(t *testing.T) {
+	b := NewBus()
+
+	var got []interface{}
+	SubscribeAll(b, func(ctx context.Context, ev interface{}, info EventInfo) error {
+		got = append(got, ev)
+		return nil
+	}, Where("ID", 2))
+
+	b.Dispatch(&testFilterEvent{ID: 1})
+	b.Dispatch(&testFilterEvent{ID: 2, Name: "two"})
+	b.Dispatch(testEvent1{})
+
+	if want := []interface{}{&testFilterEvent{ID: 2, Name: "two"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wildcard listener got %v, want %v", got, want)
+	}
+}
+
+func TestSubscribeAllCancelAndError(t *testing.T) {
+	b := NewBus()
+
+	var failures []*ListenerError
+	b.SetErrorHandler(func(lerr *ListenerError) { failures = append(failures, lerr) })
+
+	errTest := errors.New("test error")
+	count := 0
+	sub := SubscribeAll(b, func(ctx context.Context, ev interface{}, info EventInfo) error {
+		count++
+		return errTest
+	}, Filter(func(ev interface{}) bool {
+		_, ok := ev.(ListenerFailed)
+		return !ok
+	}))
+
+	b.Dispatch(testEvent1{})
+	sub.Cancel()
+	b.Dispatch(testEvent1{})
+
+	if count != 1 {
+		t.Errorf("wildcard listener triggered %d times, want 1", count)
+	}
+	if len(failures) != 1 || !errors.Is(failures[0], errTest) {
+		t.Errorf("error handler got %v, want the wildcard listener's error", failures)
+	}
+}
+
+func TestSubscribeAllAsync(t *testing.T) {
+	b := NewBus(Async(10, Block))
+
+	infos := make(chan EventInfo, 1)
+	SubscribeAll(b, func(ctx context.Context, ev interface{}, info EventInfo) error {
+		infos <- info
+		return nil
+	})
+	b.Dispatch(testEvent1{})
+	b.Close()
+
+	select {
+	case info := <-infos:
+		if want := "github.com/bhojpur/events/pkg/engine.testEvent1"; info.Type != want {
+			t.Errorf("info.Type = %q, want %q", info.Type, want)
+		}
+	default:
+		t.Errorf("asynchronous wildcard listener was not triggered")
+	}
+}
+
+func TestTypeName(t *testing.T) {
+	for _, tc := range []struct {
+		v    interface{}
+		want string
+	}{
+		{testEvent1{}, "github.com/bhojpur/events/pkg/engine.testEvent1"},
+		{new(*testEvent1), "**github.com/bhojpur/events/pkg/engine.testEvent1"},
+		{42, "int"},
+		{[]string{}, "[]string"},
+		{struct{ A int }{}, "struct { A int }"},
+		{nil, "nil"},
+	} {
+		if got := TypeName(reflect.TypeOf(tc.v)); got != tc.want {
+			t.Errorf("TypeName(%T) = %q, want %q", tc.v, got, tc.want)
+		}
+	}
+}
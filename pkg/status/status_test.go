@@ -72,3 +72,38 @@ func TestUpdateDispatch(t *testing.T) {
 		t.Errorf("listener wasn't triggered on Dispatch()")
 	}
 }
+
+func TestUpdateDispatchEmbedded(t *testing.T) {
+	b := engine.NewBus()
+
+	var got *StatusUpdater
+	b.AddListener(func(su *StatusUpdater) {
+		got = su
+	}, engine.Match(engine.MatchEmbedded))
+
+	ev := &testEvent{}
+	b.DispatchUpdate(ev, "status")
+
+	if got != &ev.StatusUpdater {
+		t.Errorf("listener for embedded StatusUpdater wasn't triggered with the event's StatusUpdater")
+	}
+}
+
+func TestUpdateDispatchWhereEventID(t *testing.T) {
+	b := engine.NewBus()
+
+	ev1, ev2 := &testEvent{}, &testEvent{}
+	ev1.EventID, ev2.EventID = 1, 2
+
+	var got []*testEvent
+	b.AddListener(func(ev *testEvent) {
+		got = append(got, ev)
+	}, engine.Where("EventID", ev2.EventID))
+
+	b.DispatchUpdate(ev1, "status")
+	b.DispatchUpdate(ev2, "status")
+
+	if len(got) != 1 || got[0] != ev2 {
+		t.Errorf("listener filtered on EventID got %v, want only %v", got, ev2)
+	}
+}